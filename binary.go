@@ -0,0 +1,325 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// binaryEncoder CBOR/MessagePackの共通エンコーダ
+type binaryEncoder interface {
+	writeNil()
+	writeBool(v bool)
+	writeInt(v int64)
+	writeUint(v uint64)
+	writeFloat32(v float32)
+	writeFloat64(v float64)
+	writeString(v string)
+	writeBytes(v []byte)
+	writeTime(v time.Time)
+	writeArrayHeader(n int)
+	writeMapHeader(n int)
+}
+
+// maxBinaryDepth デコード時のネストの上限
+const maxBinaryDepth = 64
+
+// encodePayload ペイロードをJSONと同じキー名のマップとしてエンコード
+func encodePayload(e binaryEncoder, p *Payload) error {
+	n := 3
+	if p.Datetime != nil {
+		n++
+	}
+	e.writeMapHeader(n)
+
+	if p.Datetime != nil {
+		e.writeString("datetime")
+		e.writeTime(*p.Datetime)
+	}
+	e.writeString("module")
+	e.writeString(p.Module)
+
+	e.writeString("payload")
+	switch {
+	case p.IsChannelValue():
+		if err := encodeInnerPayload(e, &p.Payload); err != nil {
+			return err
+		}
+	case p.Message == nil:
+		e.writeNil()
+	default:
+		data, err := json.Marshal(p.Message)
+		if err != nil {
+			return fmt.Errorf("Failed on Marshaling payload : %s", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return fmt.Errorf("Failed on Marshaling payload : %s", err)
+		}
+		encodeGeneric(e, v)
+	}
+
+	e.writeString("type")
+	e.writeString(p.Type)
+	return nil
+}
+
+// encodeInnerPayload チャンネル一覧をエンコード
+func encodeInnerPayload(e binaryEncoder, p *InnerPayload) error {
+	e.writeMapHeader(1)
+	e.writeString("channels")
+	if p.Channels == nil {
+		e.writeNil()
+		return nil
+	}
+
+	e.writeArrayHeader(len(p.Channels))
+	for i := range p.Channels {
+		if err := encodeChannel(e, &p.Channels[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeChannel 型コードに応じたネイティブな型でチャンネル値をエンコード
+func encodeChannel(e binaryEncoder, c *Channel) error {
+	v, err := c.Typed()
+	if err != nil {
+		return fmt.Errorf("Channel %d: %s", c.Channel, err)
+	}
+
+	n := 3
+	if c.Datetime != nil {
+		n++
+	}
+	e.writeMapHeader(n)
+
+	e.writeString("channel")
+	e.writeInt(c.Channel)
+	e.writeString("type")
+	e.writeString(c.Type)
+
+	e.writeString("value")
+	switch v := v.Value.(type) {
+	case int32:
+		e.writeInt(int64(v))
+	case uint32:
+		e.writeUint(uint64(v))
+	case int64:
+		e.writeInt(v)
+	case uint64:
+		e.writeUint(v)
+	case float32:
+		e.writeFloat32(v)
+	case float64:
+		e.writeFloat64(v)
+	case [PackedSize]byte:
+		e.writeBytes(v[:])
+	}
+
+	if c.Datetime != nil {
+		e.writeString("datetime")
+		e.writeTime(*c.Datetime)
+	}
+	return nil
+}
+
+// encodeGeneric JSONからデコードした値をエンコード
+func encodeGeneric(e binaryEncoder, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.writeNil()
+	case bool:
+		e.writeBool(v)
+	case string:
+		e.writeString(v)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			e.writeInt(i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			e.writeUint(u)
+		} else {
+			f, _ := v.Float64()
+			e.writeFloat64(f)
+		}
+	case []interface{}:
+		e.writeArrayHeader(len(v))
+		for _, item := range v {
+			encodeGeneric(e, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		e.writeMapHeader(len(keys))
+		for _, k := range keys {
+			e.writeString(k)
+			encodeGeneric(e, v[k])
+		}
+	}
+}
+
+// payloadFromTree デコード済みの値からペイロードを復元
+func payloadFromTree(v interface{}) (Payload, error) {
+	var p Payload
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return p, fmt.Errorf("Payload must be a map")
+	}
+
+	var err error
+	if p.Datetime, err = timeFromTree(m["datetime"]); err != nil {
+		return p, err
+	}
+	if p.Module, err = stringFromTree("module", m["module"]); err != nil {
+		return p, err
+	}
+	if p.Type, err = stringFromTree("type", m["type"]); err != nil {
+		return p, err
+	}
+
+	payload := m["payload"]
+	if payload == nil {
+		return p, nil
+	}
+	if p.IsChannelValue() {
+		p.Payload, err = innerPayloadFromTree(payload)
+		return p, err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return p, fmt.Errorf("Failed on decoding %q payload: %s", p.Type, err)
+	}
+	p.Message, err = decodeMessage(p.Type, data)
+	return p, err
+}
+
+// innerPayloadFromTree デコード済みの値からチャンネル一覧を復元
+func innerPayloadFromTree(v interface{}) (InnerPayload, error) {
+	var p InnerPayload
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return p, fmt.Errorf("InnerPayload must be a map")
+	}
+	if m["channels"] == nil {
+		return p, nil
+	}
+
+	list, ok := m["channels"].([]interface{})
+	if !ok {
+		return p, fmt.Errorf("channels must be an array")
+	}
+
+	p.Channels = make([]Channel, 0, len(list))
+	for _, item := range list {
+		c, err := channelFromTree(item)
+		if err != nil {
+			return p, err
+		}
+		p.Channels = append(p.Channels, c)
+	}
+	return p, nil
+}
+
+// channelFromTree デコード済みの値から型コードに応じたGoの型でチャンネルを復元
+func channelFromTree(v interface{}) (Channel, error) {
+	var c Channel
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return c, fmt.Errorf("Channel must be a map")
+	}
+
+	ch, err := (&Channel{Value: m["channel"]}).intValue(64)
+	if err != nil {
+		return c, fmt.Errorf("channel: %s", err)
+	}
+	c.Channel = ch
+
+	typ, err := stringFromTree("type", m["type"])
+	if err != nil {
+		return c, err
+	}
+	c.Type = typ
+
+	if c.Datetime, err = timeFromTree(m["datetime"]); err != nil {
+		return c, err
+	}
+
+	tmp := &Channel{Value: m["value"]}
+	switch c.ChannelType() {
+	case ChannelTypeInt:
+		var n int64
+		n, err = tmp.intValue(32)
+		c.Value = int32(n)
+	case ChannelTypeUint:
+		var n uint64
+		n, err = tmp.uintValue(32)
+		c.Value = uint32(n)
+	case ChannelTypeInt64:
+		c.Value, err = tmp.intValue(64)
+	case ChannelTypeUint64:
+		c.Value, err = tmp.uintValue(64)
+	case ChannelTypeFloat:
+		var f float64
+		f, err = tmp.floatValue(32)
+		c.Value = float32(f)
+	case ChannelTypeDouble:
+		c.Value, err = tmp.floatValue(64)
+	case ChannelTypeHexString:
+		switch b := m["value"].(type) {
+		case []byte:
+			c.Value = hex.EncodeToString(b)
+		case string:
+			c.Value = b
+		default:
+			err = fmt.Errorf("Value is not a byte string")
+		}
+	default:
+		err = fmt.Errorf("Unknown channel type %q", c.Type)
+	}
+	if err != nil {
+		return c, fmt.Errorf("Channel %d: %s", c.Channel, err)
+	}
+	return c, nil
+}
+
+func stringFromTree(key string, v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func timeFromTree(v interface{}) (*time.Time, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		return &v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid datetime %q", v)
+		}
+		return &t, nil
+	}
+	return nil, fmt.Errorf("datetime must be a timestamp")
+}
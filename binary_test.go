@@ -0,0 +1,229 @@
+package sakura
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var binaryTestJSON = []string{
+	`{"module":"XXXXXXXXX","type":"channels","datetime":"2016-06-01T12:21:11.628907163Z","payload":{"channels":[
+		{"channel":0,"type":"i","value":-2147483648,"datetime":"2016-12-04T04:14:27.214224349Z"},
+		{"channel":1,"type":"I","value":4294967295},
+		{"channel":2,"type":"l","value":-9223372036854775808},
+		{"channel":3,"type":"L","value":18446744073709551615},
+		{"channel":4,"type":"f","value":0.1},
+		{"channel":5,"type":"d","value":0.1},
+		{"channel":6,"type":"b","value":"0f1e2d3c4b5c6b7a"}
+	]}}`,
+	connectionTestJSON,
+	locationTestJSON,
+	keepAliveTestJSON,
+	unknownTestJSON,
+}
+
+type binaryCodec struct {
+	name      string
+	marshal   func(p Payload) ([]byte, error)
+	unmarshal func(data []byte, p *Payload) error
+}
+
+var binaryCodecs = []binaryCodec{
+	{
+		name:      "CBOR",
+		marshal:   func(p Payload) ([]byte, error) { return p.MarshalCBOR() },
+		unmarshal: func(data []byte, p *Payload) error { return p.UnmarshalCBOR(data) },
+	},
+	{
+		name:      "MessagePack",
+		marshal:   func(p Payload) ([]byte, error) { return p.MarshalMsgpack() },
+		unmarshal: func(data []byte, p *Payload) error { return p.UnmarshalMsgpack(data) },
+	},
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+
+	for _, codec := range binaryCodecs {
+		for _, src := range binaryTestJSON {
+			var expect Payload
+			err := json.Unmarshal([]byte(src), &expect)
+			assert.NoError(t, err)
+
+			data, err := codec.marshal(expect)
+			assert.NoError(t, err, codec.name)
+
+			var actual Payload
+			err = codec.unmarshal(data, &actual)
+			assert.NoError(t, err, codec.name)
+
+			assert.Equal(t, actual.Module, expect.Module, codec.name)
+			assert.Equal(t, actual.Type, expect.Type, codec.name)
+			if expect.Datetime != nil && assert.NotNil(t, actual.Datetime, codec.name) {
+				assert.True(t, actual.Datetime.Equal(*expect.Datetime), codec.name)
+			}
+			if raw, ok := expect.Message.(json.RawMessage); ok {
+				actualRaw, ok := actual.Message.(json.RawMessage)
+				assert.True(t, ok, codec.name)
+				assert.JSONEq(t, string(actualRaw), string(raw), codec.name)
+			} else {
+				assert.Equal(t, actual.Message, expect.Message, codec.name)
+			}
+			assert.Len(t, actual.Payload.Channels, len(expect.Payload.Channels), codec.name)
+
+			for i := range expect.Payload.Channels {
+				e, a := expect.Payload.Channels[i], actual.Payload.Channels[i]
+				assert.Equal(t, a.Channel, e.Channel, codec.name)
+				assert.Equal(t, a.Type, e.Type, codec.name)
+
+				ev, err := e.Typed()
+				assert.NoError(t, err)
+				av, err := a.Typed()
+				assert.NoError(t, err, codec.name)
+				assert.Equal(t, av, ev, codec.name)
+
+				if e.Datetime != nil && assert.NotNil(t, a.Datetime, codec.name) {
+					assert.True(t, a.Datetime.Equal(*e.Datetime), codec.name)
+				}
+			}
+
+			// JSON representation is preserved too
+			expectJSON, _ := json.Marshal(expect)
+			actualJSON, _ := json.Marshal(actual)
+			if expect.IsChannelValue() {
+				assert.Contains(t, string(actualJSON), "18446744073709551615", codec.name)
+			} else {
+				assert.JSONEq(t, string(actualJSON), string(expectJSON), codec.name)
+			}
+		}
+	}
+}
+
+func TestBinaryCodecTimezone(t *testing.T) {
+
+	jst := time.Date(2016, 6, 1, 21, 21, 11, 628907163, time.FixedZone("JST", 9*60*60))
+	p := NewPayload("XXXXXXXXX")
+	p.Datetime = &jst
+
+	// CBORはタイムゾーンを保持する
+	data, err := p.MarshalCBOR()
+	assert.NoError(t, err)
+	var decoded Payload
+	assert.NoError(t, decoded.UnmarshalCBOR(data))
+	assert.Equal(t, decoded.Datetime.Format(time.RFC3339Nano), "2016-06-01T21:21:11.628907163+09:00")
+
+	// MessagePackはタイムゾーンを持たないため、同じ時刻のUTCとなる
+	data, err = p.MarshalMsgpack()
+	assert.NoError(t, err)
+	decoded = Payload{}
+	assert.NoError(t, decoded.UnmarshalMsgpack(data))
+	assert.Equal(t, decoded.Datetime.Format(time.RFC3339Nano), "2016-06-01T12:21:11.628907163Z")
+	assert.True(t, decoded.Datetime.Equal(jst))
+}
+
+func TestBinaryCodecChannel(t *testing.T) {
+
+	c := newChannel(1)
+	c.SetInt(-1)
+
+	// {"channel": 1, "type": "i", "value": -1}
+	data, err := c.MarshalCBOR()
+	assert.NoError(t, err)
+	assert.Equal(t, data, []byte{
+		0xa3,
+		0x67, 'c', 'h', 'a', 'n', 'n', 'e', 'l', 0x01,
+		0x64, 't', 'y', 'p', 'e', 0x61, 'i',
+		0x65, 'v', 'a', 'l', 'u', 'e', 0x20,
+	})
+
+	data, err = c.MarshalMsgpack()
+	assert.NoError(t, err)
+	assert.Equal(t, data, []byte{
+		0x83,
+		0xa7, 'c', 'h', 'a', 'n', 'n', 'e', 'l', 0x01,
+		0xa4, 't', 'y', 'p', 'e', 0xa1, 'i',
+		0xa5, 'v', 'a', 'l', 'u', 'e', 0xff,
+	})
+
+	// hex string channels are encoded as native byte strings
+	c = newChannel(0)
+	c.SetHexString("0F1E2D3C4B5C6B7A")
+	data, err = c.MarshalCBOR()
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), string([]byte{0x48, 0x0f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5c, 0x6b, 0x7a}))
+
+	var decoded Channel
+	assert.NoError(t, decoded.UnmarshalCBOR(data))
+	assert.Equal(t, decoded.Value, "0f1e2d3c4b5c6b7a")
+
+	// invalid channel can't be encoded
+	c.SetHexString("FF01")
+	_, err = c.MarshalCBOR()
+	assert.Error(t, err)
+	_, err = c.MarshalMsgpack()
+	assert.Error(t, err)
+}
+
+func TestBinaryCodecInnerPayload(t *testing.T) {
+
+	p := NewPayload("xxxxxxxx10xx")
+	p.AddValueByUint64(0, 18446744073709551615)
+	p.AddValueByFloat(1, 0.1)
+
+	data, err := p.Payload.MarshalCBOR()
+	assert.NoError(t, err)
+	var inner InnerPayload
+	assert.NoError(t, inner.UnmarshalCBOR(data))
+	assert.Equal(t, inner, p.Payload)
+
+	data, err = p.Payload.MarshalMsgpack()
+	assert.NoError(t, err)
+	inner = InnerPayload{}
+	assert.NoError(t, inner.UnmarshalMsgpack(data))
+	assert.Equal(t, inner, p.Payload)
+}
+
+func TestCBORDecodeIndefinite(t *testing.T) {
+
+	// {_ "channel": 0, "type": (_ "i"), "value": 1}
+	data := []byte{
+		0xbf,
+		0x67, 'c', 'h', 'a', 'n', 'n', 'e', 'l', 0x00,
+		0x64, 't', 'y', 'p', 'e', 0x7f, 0x61, 'i', 0xff,
+		0x65, 'v', 'a', 'l', 'u', 'e', 0x01,
+		0xff,
+	}
+
+	var c Channel
+	assert.NoError(t, c.UnmarshalCBOR(data))
+	assert.Equal(t, c.ChannelType(), ChannelTypeInt)
+	assert.Equal(t, c.Value, int32(1))
+}
+
+func TestBinaryCodecInvalidData(t *testing.T) {
+
+	invalids := [][]byte{
+		{},
+		{0xa1},             // truncated map
+		{0xa1, 0x01, 0x01}, // non-string key
+		{0xff},             // unexpected break
+		{0xa0, 0x00},       // trailing data
+	}
+	for _, data := range invalids {
+		var p Payload
+		assert.Error(t, p.UnmarshalCBOR(data))
+	}
+
+	invalids = [][]byte{
+		{},
+		{0x81},             // truncated map
+		{0x81, 0x01, 0x01}, // non-string key
+		{0xc1},             // never used
+		{0x80, 0x00},       // trailing data
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+	}
+	for _, data := range invalids {
+		var p Payload
+		assert.Error(t, p.UnmarshalMsgpack(data))
+	}
+}
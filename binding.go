@@ -0,0 +1,340 @@
+package sakura
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BindingTagName 構造体とチャンネルを対応付けるstructタグ名
+//
+// `sakura:"ch=3,type=f"`のように、チャンネル番号(ch)と型コード(type)を指定する。
+// typeを省略した場合はフィールドの型から決定される。
+// (int32:i / uint32:I / int64:l / uint64:L / float32:f / float64:d / [8]byte,string:b)
+const BindingTagName = "sakura"
+
+// bindingField structタグで対応付けられたフィールド
+type bindingField struct {
+	name    string
+	index   []int
+	channel int64
+	typ     ChannelType
+}
+
+// Marshal structタグに従い構造体からペイロードを作成
+//
+// nilのポインタフィールドは出力しない
+func Marshal(module string, v interface{}) (Payload, error) {
+	p := NewPayload(module)
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return p, fmt.Errorf("Marshal: nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return p, fmt.Errorf("Marshal: %s is not a struct", rv.Type())
+	}
+
+	fields, err := bindingFields(rv.Type())
+	if err != nil {
+		return p, err
+	}
+
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if err := addBindingValue(&p, f, fv); err != nil {
+			return p, err
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Unmarshal structタグに従いペイロードのチャンネル値を構造体へ設定
+//
+// ペイロードに含まれないチャンネルに対応するフィールドは変更しない
+func Unmarshal(p Payload, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Unmarshal: non-nil pointer is required")
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("Unmarshal: %s is not a struct", rv.Type())
+	}
+
+	fields, err := bindingFields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	channels := map[int64]*Channel{}
+	for i := range p.Payload.Channels {
+		c := &p.Payload.Channels[i]
+		channels[c.Channel] = c
+	}
+
+	for _, f := range fields {
+		c, ok := channels[f.channel]
+		if !ok {
+			continue
+		}
+
+		fv := rv.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		if err := setBindingValue(fv, f, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindingFields structタグが指定されたフィールドを列挙(埋め込み構造体を含む)
+func bindingFields(t reflect.Type) ([]bindingField, error) {
+	ret := []bindingField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup(BindingTagName)
+
+		if !ok && sf.Anonymous {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				return nil, fmt.Errorf("Field %s: embedded pointer is not supported", sf.Name)
+			}
+			if ft.Kind() == reflect.Struct {
+				fields, err := bindingFields(ft)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range fields {
+					f.index = append([]int{i}, f.index...)
+					ret = append(ret, f)
+				}
+			}
+			continue
+		}
+		if !ok || tag == "-" {
+			continue
+		}
+		if sf.PkgPath != "" {
+			return nil, fmt.Errorf("Field %s: unexported field can't be bound", sf.Name)
+		}
+
+		f, err := parseBindingTag(sf, tag)
+		if err != nil {
+			return nil, err
+		}
+		f.index = []int{i}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// parseBindingTag structタグ(ch=N,type=X)を解析
+func parseBindingTag(sf reflect.StructField, tag string) (bindingField, error) {
+	f := bindingField{name: sf.Name, channel: -1}
+
+	for _, opt := range strings.Split(tag, ",") {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return f, fmt.Errorf("Field %s: invalid tag option %q", sf.Name, opt)
+		}
+		switch kv[0] {
+		case "ch":
+			ch, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || ch < ChannelMin || ChannelMax < ch {
+				return f, fmt.Errorf("Field %s: invalid channel %q", sf.Name, kv[1])
+			}
+			f.channel = ch
+		case "type":
+			f.typ = ChannelType(kv[1])
+			if !f.typ.IsValid() {
+				return f, fmt.Errorf("Field %s: unknown channel type %q", sf.Name, kv[1])
+			}
+		default:
+			return f, fmt.Errorf("Field %s: unknown tag option %q", sf.Name, kv[0])
+		}
+	}
+
+	if f.channel < 0 {
+		return f, fmt.Errorf("Field %s: ch is required", sf.Name)
+	}
+
+	ft := sf.Type
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	inferred, ok := inferChannelType(ft)
+	if !ok {
+		return f, fmt.Errorf("Field %s: %s can't be bound", sf.Name, sf.Type)
+	}
+	if f.typ == "" {
+		f.typ = inferred
+	}
+	if (f.typ == ChannelTypeHexString) != (inferred == ChannelTypeHexString) {
+		return f, fmt.Errorf("Field %s: %s can't be bound to type %q", sf.Name, sf.Type, f.typ)
+	}
+	return f, nil
+}
+
+// inferChannelType フィールドの型から型コードを決定
+func inferChannelType(t reflect.Type) (ChannelType, bool) {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return ChannelTypeInt, true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return ChannelTypeUint, true
+	case reflect.Int, reflect.Int64:
+		return ChannelTypeInt64, true
+	case reflect.Uint, reflect.Uint64:
+		return ChannelTypeUint64, true
+	case reflect.Float32:
+		return ChannelTypeFloat, true
+	case reflect.Float64:
+		return ChannelTypeDouble, true
+	case reflect.String:
+		return ChannelTypeHexString, true
+	case reflect.Array:
+		if t.Len() == PackedSize && t.Elem().Kind() == reflect.Uint8 {
+			return ChannelTypeHexString, true
+		}
+	}
+	return "", false
+}
+
+// addBindingValue フィールドの値をペイロードへ追加
+func addBindingValue(p *Payload, f bindingField, fv reflect.Value) error {
+	var src interface{}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		src = fv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		src = fv.Uint()
+	case reflect.Float32, reflect.Float64:
+		src = fv.Float()
+	}
+	tmp := &Channel{Value: src}
+
+	var err error
+	switch f.typ {
+	case ChannelTypeInt:
+		var v int64
+		if v, err = tmp.intValue(32); err == nil {
+			p.AddValueByInt(f.channel, int32(v))
+		}
+	case ChannelTypeUint:
+		var v uint64
+		if v, err = tmp.uintValue(32); err == nil {
+			p.AddValueByUint(f.channel, uint32(v))
+		}
+	case ChannelTypeInt64:
+		var v int64
+		if v, err = tmp.intValue(64); err == nil {
+			p.AddValueByInt64(f.channel, v)
+		}
+	case ChannelTypeUint64:
+		var v uint64
+		if v, err = tmp.uintValue(64); err == nil {
+			p.AddValueByUint64(f.channel, v)
+		}
+	case ChannelTypeFloat:
+		var v float64
+		if v, err = tmp.floatValue(32); err == nil {
+			p.AddValueByFloat(f.channel, float32(v))
+		}
+	case ChannelTypeDouble:
+		var v float64
+		if v, err = tmp.floatValue(64); err == nil {
+			p.AddValueByDouble(f.channel, v)
+		}
+	case ChannelTypeHexString:
+		if fv.Kind() == reflect.String {
+			p.AddValueByHexString(f.channel, fv.String())
+		} else {
+			var b [PackedSize]byte
+			reflect.Copy(reflect.ValueOf(&b).Elem(), fv)
+			p.AddValueByBytes(f.channel, b)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Field %s: %s", f.name, err)
+	}
+	return nil
+}
+
+// setBindingValue チャンネルの値をフィールドへ設定
+func setBindingValue(fv reflect.Value, f bindingField, c *Channel) error {
+	var (
+		v   interface{}
+		err error
+	)
+
+	switch f.typ {
+	case ChannelTypeInt:
+		v, err = c.GetInt()
+	case ChannelTypeUint:
+		v, err = c.GetUint()
+	case ChannelTypeInt64:
+		v, err = c.GetInt64()
+	case ChannelTypeUint64:
+		v, err = c.GetUint64()
+	case ChannelTypeFloat:
+		v, err = c.GetFloat()
+	case ChannelTypeDouble:
+		v, err = c.GetDouble()
+	case ChannelTypeHexString:
+		if fv.Kind() == reflect.String {
+			v, err = c.GetHexString()
+		} else {
+			v, err = c.GetBytes()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Field %s: channel %d: %s", f.name, c.Channel, err)
+	}
+
+	tmp := &Channel{Value: v}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = tmp.intValue(fv.Type().Bits()); err == nil {
+			fv.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = tmp.uintValue(fv.Type().Bits()); err == nil {
+			fv.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		if n, err = tmp.floatValue(fv.Type().Bits()); err == nil {
+			fv.SetFloat(n)
+		}
+	case reflect.String:
+		fv.SetString(v.(string))
+	case reflect.Array:
+		reflect.Copy(fv, reflect.ValueOf(v))
+	}
+	if err != nil {
+		return fmt.Errorf("Field %s: channel %d: %s", f.name, c.Channel, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package sakura
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type bindingTestBase struct {
+	Counter uint64 `sakura:"ch=0"`
+}
+
+type bindingTestStruct struct {
+	bindingTestBase
+	Temperature float32 `sakura:"ch=1"`
+	Humidity    float64 `sakura:"ch=2"`
+	Level       int     `sakura:"ch=3,type=i"`
+	Status      uint8   `sakura:"ch=4"`
+	Packed      [8]byte `sakura:"ch=5"`
+	Raw         string  `sakura:"ch=6"`
+	Optional    *int32  `sakura:"ch=7"`
+	Ignored     string  `sakura:"-"`
+	NoTag       int     ``
+}
+
+func TestBindingMarshal(t *testing.T) {
+
+	v := bindingTestStruct{
+		bindingTestBase: bindingTestBase{Counter: 18446744073709551615},
+		Temperature:     21.5,
+		Humidity:        0.5,
+		Level:           -3,
+		Status:          1,
+		Packed:          [8]byte{0x0f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5c, 0x6b, 0x7a},
+		Raw:             "0102030405060708",
+	}
+
+	p, err := Marshal("xxxxxxxx10xx", &v)
+	assert.NoError(t, err)
+	assert.Equal(t, p.Module, "xxxxxxxx10xx")
+	assert.Len(t, p.Payload.Channels, 7)
+
+	expects := []struct {
+		channel int64
+		typ     ChannelType
+		value   interface{}
+	}{
+		{channel: 0, typ: ChannelTypeUint64, value: uint64(18446744073709551615)},
+		{channel: 1, typ: ChannelTypeFloat, value: float32(21.5)},
+		{channel: 2, typ: ChannelTypeDouble, value: float64(0.5)},
+		{channel: 3, typ: ChannelTypeInt, value: int32(-3)},
+		{channel: 4, typ: ChannelTypeUint, value: uint32(1)},
+		{channel: 5, typ: ChannelTypeHexString, value: "0f1e2d3c4b5c6b7a"},
+		{channel: 6, typ: ChannelTypeHexString, value: "0102030405060708"},
+	}
+	for i, expect := range expects {
+		c := p.Payload.Channels[i]
+		assert.Equal(t, c.Channel, expect.channel)
+		assert.Equal(t, c.ChannelType(), expect.typ)
+		assert.Equal(t, c.Value, expect.value)
+	}
+
+	// overflow
+	v.Level = 1 << 40
+	_, err = Marshal("xxxxxxxx10xx", v)
+	assert.Error(t, err)
+}
+
+func TestBindingUnmarshal(t *testing.T) {
+
+	var payload Payload
+	err := json.Unmarshal([]byte(`{
+		"module": "xxxxxxxx10xx",
+		"type": "channels",
+		"payload": {"channels": [
+			{"channel": 0, "type": "L", "value": 18446744073709551615},
+			{"channel": 1, "type": "f", "value": 21.5},
+			{"channel": 2, "type": "d", "value": 0.5},
+			{"channel": 3, "type": "i", "value": -3},
+			{"channel": 4, "type": "I", "value": 1},
+			{"channel": 5, "type": "b", "value": "0f1e2d3c4b5c6b7a"},
+			{"channel": 7, "type": "i", "value": 7},
+			{"channel": 8, "type": "i", "value": 8}
+		]}
+	}`), &payload)
+	assert.NoError(t, err)
+
+	v := bindingTestStruct{Raw: "0102030405060708"}
+	err = Unmarshal(payload, &v)
+	assert.NoError(t, err)
+
+	assert.Equal(t, v.Counter, uint64(18446744073709551615))
+	assert.Equal(t, v.Temperature, float32(21.5))
+	assert.Equal(t, v.Humidity, float64(0.5))
+	assert.Equal(t, v.Level, -3)
+	assert.Equal(t, v.Status, uint8(1))
+	assert.Equal(t, v.Packed, [8]byte{0x0f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5c, 0x6b, 0x7a})
+	assert.Equal(t, v.Raw, "0102030405060708")
+	if assert.NotNil(t, v.Optional) {
+		assert.Equal(t, *v.Optional, int32(7))
+	}
+
+	// round trip
+	p, err := Marshal("xxxxxxxx10xx", v)
+	assert.NoError(t, err)
+	var w bindingTestStruct
+	assert.NoError(t, Unmarshal(p, &w))
+	assert.Equal(t, w, v)
+}
+
+func TestBindingUnmarshalErrors(t *testing.T) {
+
+	payload := NewPayload("xxxxxxxx10xx")
+	payload.AddValueByInt(4, -1)
+
+	// type mismatch (ch=4 is bound to "I")
+	var v bindingTestStruct
+	assert.Error(t, Unmarshal(payload, &v))
+
+	// out of range for uint8
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.AddValueByUint(4, 256)
+	assert.Error(t, Unmarshal(payload, &v))
+
+	// not a pointer
+	assert.Error(t, Unmarshal(payload, v))
+
+	// invalid tags
+	var noChannel struct {
+		V int32 `sakura:"type=i"`
+	}
+	assert.Error(t, Unmarshal(payload, &noChannel))
+
+	var badType struct {
+		V int32 `sakura:"ch=0,type=b"`
+	}
+	assert.Error(t, Unmarshal(payload, &badType))
+
+	var unsupported struct {
+		V bool `sakura:"ch=0"`
+	}
+	assert.Error(t, Unmarshal(payload, &unsupported))
+}
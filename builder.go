@@ -0,0 +1,184 @@
+package sakura
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DuplicatePolicy Builderで同じチャンネルが複数回指定された場合の扱い
+type DuplicatePolicy int
+
+const (
+	// DuplicateReject 重複したチャンネルをエラーとする(デフォルト)
+	DuplicateReject DuplicatePolicy = iota
+	// DuplicateReplace 後から指定した値で置き換える
+	DuplicateReplace
+	// DuplicateAllow 重複したチャンネルをそのまま追加する
+	DuplicateAllow
+)
+
+// BuilderError Builderで発生したエラーのリスト
+type BuilderError struct {
+	Errors []error
+}
+
+// Error エラーメッセージを改行区切りで連結
+func (e *BuilderError) Error() string {
+	list := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		list = append(list, err.Error())
+	}
+	return strings.Join(list, "\n")
+}
+
+// Builder チャンネル毎の時刻指定や検証を行いながらペイロードを作成する
+//
+//	p, err := sakura.NewBuilder(module).Int(0, v).At(t).Hex(1, s).Build()
+//
+// 不正な値や重複したチャンネルはエラーとして蓄積され、Build()で返される
+type Builder struct {
+	payload Payload
+	policy  DuplicatePolicy
+	index   map[int64]int
+	last    int
+	// rejected 直前のチャンネル追加がエラーとなったか
+	rejected bool
+	errors   []error
+}
+
+// NewBuilder 新規*Builder作成
+func NewBuilder(module string) *Builder {
+	return &Builder{
+		payload: NewPayload(module),
+		index:   map[int64]int{},
+		last:    -1,
+	}
+}
+
+// OnDuplicate 同じチャンネルが複数回指定された場合の扱いを設定
+func (b *Builder) OnDuplicate(policy DuplicatePolicy) *Builder {
+	b.policy = policy
+	return b
+}
+
+// Datetime ペイロード(メッセージ)の時刻を設定
+func (b *Builder) Datetime(t time.Time) *Builder {
+	b.payload.Datetime = &t
+	return b
+}
+
+// At 直前に追加したチャンネルの時刻を設定
+//
+// 直前のチャンネル追加がエラーとなっていた場合は何もしない(エラーは追加時に記録済み)
+func (b *Builder) At(t time.Time) *Builder {
+	if b.rejected {
+		return b
+	}
+	if b.last < 0 {
+		b.errors = append(b.errors, fmt.Errorf("At: no channel to set datetime"))
+		return b
+	}
+	b.payload.Payload.Channels[b.last].Datetime = &t
+	return b
+}
+
+func (b *Builder) add(c Channel) *Builder {
+	b.last = -1
+	b.rejected = false
+
+	if err := c.Validate(); err != nil {
+		b.errors = append(b.errors, err)
+		b.rejected = true
+		return b
+	}
+
+	channels := b.payload.Payload.Channels
+	if i, ok := b.index[c.Channel]; ok {
+		switch b.policy {
+		case DuplicateReject:
+			b.errors = append(b.errors, fmt.Errorf("Channel %d: duplicated", c.Channel))
+			b.rejected = true
+			return b
+		case DuplicateReplace:
+			channels[i] = c
+			b.last = i
+			return b
+		}
+	}
+
+	b.index[c.Channel] = len(channels)
+	b.last = len(channels)
+	b.payload.Payload.Channels = append(channels, c)
+	return b
+}
+
+// Int int32型の値を追加
+func (b *Builder) Int(channel int64, v int32) *Builder {
+	c := newChannel(channel)
+	c.SetInt(v)
+	return b.add(c)
+}
+
+// Uint uint32型の値を追加
+func (b *Builder) Uint(channel int64, v uint32) *Builder {
+	c := newChannel(channel)
+	c.SetUint(v)
+	return b.add(c)
+}
+
+// Int64 int64型の値を追加
+func (b *Builder) Int64(channel int64, v int64) *Builder {
+	c := newChannel(channel)
+	c.SetInt64(v)
+	return b.add(c)
+}
+
+// Uint64 uint64型の値を追加
+func (b *Builder) Uint64(channel int64, v uint64) *Builder {
+	c := newChannel(channel)
+	c.SetUint64(v)
+	return b.add(c)
+}
+
+// Float float(float32)型の値を追加
+func (b *Builder) Float(channel int64, v float32) *Builder {
+	c := newChannel(channel)
+	c.SetFloat(v)
+	return b.add(c)
+}
+
+// Double double(float64)型の値を追加
+func (b *Builder) Double(channel int64, v float64) *Builder {
+	c := newChannel(channel)
+	c.SetDouble(v)
+	return b.add(c)
+}
+
+// Hex 16進文字列(16文字で1セット)を追加
+func (b *Builder) Hex(channel int64, v string) *Builder {
+	c := newChannel(channel)
+	c.SetHexString(v)
+	return b.add(c)
+}
+
+// Bytes 8バイトの配列を16進文字列として追加
+func (b *Builder) Bytes(channel int64, v [PackedSize]byte) *Builder {
+	c := newChannel(channel)
+	c.SetBytes(v)
+	return b.add(c)
+}
+
+// Build ペイロードを作成(エラーが蓄積されている場合は*BuilderErrorを返す)
+func (b *Builder) Build() (Payload, error) {
+	p := b.payload
+	p.Payload.Channels = append([]Channel{}, b.payload.Payload.Channels...)
+
+	if len(b.errors) != 0 {
+		return p, &BuilderError{Errors: append([]error{}, b.errors...)}
+	}
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
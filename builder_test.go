@@ -0,0 +1,96 @@
+package sakura
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBuilder(t *testing.T) {
+
+	t1 := time.Date(2016, 12, 4, 4, 14, 27, 0, time.UTC)
+	t2 := t1.Add(time.Second)
+
+	p, err := NewBuilder("xxxxxxxx10xx").
+		Datetime(t2).
+		Int(0, -1).At(t1).
+		Uint(1, 1).
+		Int64(2, -2).At(t2).
+		Uint64(3, 18446744073709551615).
+		Float(4, 0.5).
+		Double(5, 0.25).
+		Hex(6, "0f1e2d3c4b5c6b7a").
+		Bytes(7, [8]byte{1}).
+		Build()
+	assert.NoError(t, err)
+
+	assert.Equal(t, p.Module, "xxxxxxxx10xx")
+	assert.Equal(t, *p.Datetime, t2)
+	assert.Len(t, p.Payload.Channels, 8)
+
+	assert.Equal(t, *p.Payload.Channels[0].Datetime, t1)
+	assert.Nil(t, p.Payload.Channels[1].Datetime)
+	assert.Equal(t, *p.Payload.Channels[2].Datetime, t2)
+
+	expects := []ChannelType{
+		ChannelTypeInt, ChannelTypeUint, ChannelTypeInt64, ChannelTypeUint64,
+		ChannelTypeFloat, ChannelTypeDouble, ChannelTypeHexString, ChannelTypeHexString,
+	}
+	for i, expect := range expects {
+		assert.EqualValues(t, p.Payload.Channels[i].Channel, i)
+		assert.Equal(t, p.Payload.Channels[i].ChannelType(), expect)
+	}
+	assert.Equal(t, p.Payload.Channels[7].Value, "0100000000000000")
+}
+
+func TestBuilderErrors(t *testing.T) {
+
+	_, err := NewBuilder("xxxxxxxx10xx").
+		At(time.Now()).
+		Int(0, 1).
+		Int(0, 2).
+		Hex(1, "FF01").At(time.Now()).
+		Int(128, 1).
+		Build()
+
+	if assert.Error(t, err) {
+		berr, ok := err.(*BuilderError)
+		if assert.True(t, ok) {
+			// At without channel, duplicated ch0, invalid hex, ch128
+			// (At after invalid value is ignored)
+			assert.Len(t, berr.Errors, 4)
+		}
+		assert.Equal(t, err.Error(), "At: no channel to set datetime\n"+
+			"Channel 0: duplicated\n"+
+			"Channel 1: HexString \"FF01\" must be 16 characters\n"+
+			"Channel 128: channel must be between 0 to 127")
+	}
+
+	_, err = NewBuilder("").Int(0, 1).Build()
+	assert.Error(t, err)
+}
+
+func TestBuilderDuplicatePolicy(t *testing.T) {
+
+	now := time.Now()
+
+	p, err := NewBuilder("xxxxxxxx10xx").
+		OnDuplicate(DuplicateReplace).
+		Int(0, 1).
+		Int(1, 1).
+		Double(0, 2).At(now).
+		Build()
+	assert.NoError(t, err)
+	assert.Len(t, p.Payload.Channels, 2)
+	assert.Equal(t, p.Payload.Channels[0].ChannelType(), ChannelTypeDouble)
+	assert.Equal(t, p.Payload.Channels[0].Value, float64(2))
+	assert.Equal(t, *p.Payload.Channels[0].Datetime, now)
+
+	p, err = NewBuilder("xxxxxxxx10xx").
+		OnDuplicate(DuplicateAllow).
+		Int(0, 1).
+		Int(0, 2).
+		Build()
+	assert.NoError(t, err)
+	assert.Len(t, p.Payload.Channels, 2)
+}
@@ -0,0 +1,412 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CBOR(RFC 7049)のメジャータイプ
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// MarshalCBOR ペイロードをCBORへエンコード
+//
+// キー名はJSONと同じ、日時はタグ0(RFC3339文字列、タイムゾーンを保持)、型コード"b"の値はバイト列となる
+func (p Payload) MarshalCBOR() ([]byte, error) {
+	e := &cborEncoder{}
+	if err := encodePayload(e, &p); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalCBOR CBORからペイロードをデコード
+func (p *Payload) UnmarshalCBOR(data []byte) error {
+	v, err := decodeCBOR(data)
+	if err != nil {
+		return err
+	}
+	*p, err = payloadFromTree(v)
+	return err
+}
+
+// MarshalCBOR チャンネル一覧をCBORへエンコード
+func (p InnerPayload) MarshalCBOR() ([]byte, error) {
+	e := &cborEncoder{}
+	if err := encodeInnerPayload(e, &p); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalCBOR CBORからチャンネル一覧をデコード
+func (p *InnerPayload) UnmarshalCBOR(data []byte) error {
+	v, err := decodeCBOR(data)
+	if err != nil {
+		return err
+	}
+	*p, err = innerPayloadFromTree(v)
+	return err
+}
+
+// MarshalCBOR チャンネルをCBORへエンコード
+func (c Channel) MarshalCBOR() ([]byte, error) {
+	e := &cborEncoder{}
+	if err := encodeChannel(e, &c); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalCBOR CBORからチャンネルをデコード
+func (c *Channel) UnmarshalCBOR(data []byte) error {
+	v, err := decodeCBOR(data)
+	if err != nil {
+		return err
+	}
+	*c, err = channelFromTree(v)
+	return err
+}
+
+// cborEncoder CBORエンコーダ
+type cborEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *cborEncoder) writeHead(major byte, n uint64) {
+	var b [9]byte
+	switch {
+	case n < 24:
+		e.buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.Write([]byte{major<<5 | 24, byte(n)})
+	case n <= math.MaxUint16:
+		b[0] = major<<5 | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		e.buf.Write(b[:3])
+	case n <= math.MaxUint32:
+		b[0] = major<<5 | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.buf.Write(b[:5])
+	default:
+		b[0] = major<<5 | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.buf.Write(b[:9])
+	}
+}
+
+func (e *cborEncoder) writeNil() {
+	e.buf.WriteByte(0xf6)
+}
+
+func (e *cborEncoder) writeBool(v bool) {
+	if v {
+		e.buf.WriteByte(0xf5)
+	} else {
+		e.buf.WriteByte(0xf4)
+	}
+}
+
+func (e *cborEncoder) writeInt(v int64) {
+	if v < 0 {
+		e.writeHead(cborNegInt, uint64(-1-v))
+		return
+	}
+	e.writeHead(cborUint, uint64(v))
+}
+
+func (e *cborEncoder) writeUint(v uint64) {
+	e.writeHead(cborUint, v)
+}
+
+func (e *cborEncoder) writeFloat32(v float32) {
+	var b [5]byte
+	b[0] = 0xfa
+	binary.BigEndian.PutUint32(b[1:], math.Float32bits(v))
+	e.buf.Write(b[:])
+}
+
+func (e *cborEncoder) writeFloat64(v float64) {
+	var b [9]byte
+	b[0] = 0xfb
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(v))
+	e.buf.Write(b[:])
+}
+
+func (e *cborEncoder) writeString(v string) {
+	e.writeHead(cborText, uint64(len(v)))
+	e.buf.WriteString(v)
+}
+
+func (e *cborEncoder) writeBytes(v []byte) {
+	e.writeHead(cborBytes, uint64(len(v)))
+	e.buf.Write(v)
+}
+
+func (e *cborEncoder) writeTime(v time.Time) {
+	e.writeHead(cborTag, 0)
+	e.writeString(v.Format(time.RFC3339Nano))
+}
+
+func (e *cborEncoder) writeArrayHeader(n int) {
+	e.writeHead(cborArray, uint64(n))
+}
+
+func (e *cborEncoder) writeMapHeader(n int) {
+	e.writeHead(cborMap, uint64(n))
+}
+
+// cborDecoder CBORデコーダ
+//
+// デコード結果はuint64/int64/float32/float64/[]byte/string/bool/nil/time.Time、
+// []interface{}、map[string]interface{}のいずれかとなる
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// cborBreak 不定長データの終端を表す
+type cborBreak struct{}
+
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CBOR: %s", err)
+	}
+	if _, ok := v.(cborBreak); ok {
+		return nil, fmt.Errorf("Invalid CBOR: unexpected break")
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("Invalid CBOR: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readHead 先頭バイトを読み込み、メジャータイプ/追加情報/引数を返す
+func (d *cborDecoder) readHead() (byte, byte, uint64, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		b, err = d.read(1)
+		if err == nil {
+			arg = uint64(b[0])
+		}
+	case info == 25:
+		b, err = d.read(2)
+		if err == nil {
+			arg = uint64(binary.BigEndian.Uint16(b))
+		}
+	case info == 26:
+		b, err = d.read(4)
+		if err == nil {
+			arg = uint64(binary.BigEndian.Uint32(b))
+		}
+	case info == 27:
+		b, err = d.read(8)
+		if err == nil {
+			arg = binary.BigEndian.Uint64(b)
+		}
+	case info == 31:
+		// 不定長
+	default:
+		err = fmt.Errorf("invalid additional information %d", info)
+	}
+	return major, info, arg, err
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxBinaryDepth {
+		return nil, fmt.Errorf("too deep nesting")
+	}
+
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == 31
+
+	switch major {
+	case cborUint:
+		if indefinite {
+			return nil, fmt.Errorf("invalid indefinite integer")
+		}
+		return arg, nil
+	case cborNegInt:
+		if indefinite {
+			return nil, fmt.Errorf("invalid indefinite integer")
+		}
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("negative integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		var b []byte
+		if indefinite {
+			b, err = d.decodeChunks(major)
+		} else {
+			b, err = d.read(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	case cborArray:
+		ret := []interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := v.(cborBreak); ok {
+				if !indefinite {
+					return nil, fmt.Errorf("unexpected break")
+				}
+				break
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case cborMap:
+		ret := map[string]interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := k.(cborBreak); ok {
+				if !indefinite {
+					return nil, fmt.Errorf("unexpected break")
+				}
+				break
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key must be a text string")
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := v.(cborBreak); ok {
+				return nil, fmt.Errorf("unexpected break")
+			}
+			ret[key] = v
+		}
+		return ret, nil
+	case cborTag:
+		if indefinite {
+			return nil, fmt.Errorf("invalid indefinite tag")
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTagged(arg, v)
+	}
+
+	// cborSimple
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16ToFloat32(uint16(arg)), nil
+	case 26:
+		return math.Float32frombits(uint32(arg)), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	case 31:
+		return cborBreak{}, nil
+	}
+	return nil, fmt.Errorf("unsupported simple value %d", info)
+}
+
+// decodeChunks 不定長のバイト列/文字列を連結して読み込む
+func (d *cborDecoder) decodeChunks(major byte) ([]byte, error) {
+	ret := []byte{}
+	for {
+		m, info, arg, err := d.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if m == cborSimple && info == 31 {
+			return ret, nil
+		}
+		if m != major || info == 31 {
+			return nil, fmt.Errorf("invalid chunk in indefinite string")
+		}
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, b...)
+	}
+}
+
+// cborTagged 日時のタグ(0:RFC3339文字列, 1:エポック秒)をtime.Timeへ変換
+func cborTagged(tag uint64, v interface{}) (interface{}, error) {
+	switch tag {
+	case 0:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("tag 0 must be a text string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid datetime %q", s)
+		}
+		return t, nil
+	case 1:
+		switch n := v.(type) {
+		case uint64:
+			if n > math.MaxInt64 {
+				return nil, fmt.Errorf("epoch overflows int64")
+			}
+			return time.Unix(int64(n), 0).UTC(), nil
+		case int64:
+			return time.Unix(n, 0).UTC(), nil
+		case float32:
+			return cborTagged(tag, float64(n))
+		case float64:
+			sec, frac := math.Modf(n)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, fmt.Errorf("tag 1 must be a number")
+	}
+	return v, nil
+}
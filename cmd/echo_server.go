@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	sakura "github.com/yamamoto-febc/sakura-iot-go"
 	"github.com/yamamoto-febc/sakura-iot-go/version"
@@ -13,11 +14,13 @@ import (
 )
 
 type option struct {
-	HostName string
-	Path     string
-	Port     int
-	Secret   string
-	Debug    bool
+	HostName  string
+	Path      string
+	Port      int
+	Secret    string
+	Debug     bool
+	LogFormat string
+	LogLevel  string
 }
 
 func (o *option) validate() []error {
@@ -31,9 +34,37 @@ func (o *option) validate() []error {
 		ret = append(ret, fmt.Errorf("%s is neet between 1 to 65535", "--port"))
 	}
 
+	if o.LogFormat != "text" && o.LogFormat != "json" {
+		ret = append(ret, fmt.Errorf("%s must be one of [text json]", "--log-format"))
+	}
+
+	if _, err := sakura.ParseLogLevel(o.LogLevel); err != nil {
+		ret = append(ret, fmt.Errorf("%s is invalid: %s", "--log-level", err))
+	}
+
 	return ret
 }
 
+func (o *option) logger() sakura.Logger {
+	level, _ := sakura.ParseLogLevel(o.LogLevel)
+	if o.Debug {
+		level = sakura.LogLevelDebug
+	}
+
+	if o.LogFormat == "json" {
+		return sakura.NewJSONLogger(os.Stdout, level)
+	}
+	return sakura.NewStdLogger(log.New(os.Stdout, "", log.Ldate|log.Ltime), level)
+}
+
+func payloadJSON(p sakura.Payload) string {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Sprintf("%#v", p)
+	}
+	return string(data)
+}
+
 func newOption() *option {
 	return &option{}
 }
@@ -139,6 +170,22 @@ func cliFlags(option *option) []cli.Flag {
 			Destination: &option.Debug,
 			Value:       false,
 		},
+		&cli.StringFlag{
+			Name:        "log-format",
+			EnvVars:     []string{"SAKURA_IOT_ECHO_LOG_FORMAT"},
+			DefaultText: "text",
+			Value:       "text",
+			Destination: &option.LogFormat,
+			Usage:       "Log format [text/json]",
+		},
+		&cli.StringFlag{
+			Name:        "log-level",
+			EnvVars:     []string{"SAKURA_IOT_ECHO_LOG_LEVEL"},
+			DefaultText: "info",
+			Value:       "info",
+			Destination: &option.LogLevel,
+			Usage:       "Log level [debug/info/warn/error] (--debug is same as debug)",
+		},
 	}
 
 }
@@ -151,24 +198,25 @@ func cliCommand(option *option) func(c *cli.Context) error {
 			return flattenErrors(errors)
 		}
 
-		log.SetFlags(log.Ldate | log.Ltime)
-		log.SetOutput(os.Stdout)
-		out := log.Printf
+		logger := option.logger()
 
 		handler := &sakura.WebhookHandler{
 			Secret: option.Secret,
 			ConnectedFunc: func(p sakura.Payload) {
-				out("[INFO] Connected module message received:\n%#v", p)
+				logger.Log(sakura.LogLevelInfo, "Connected module message received", "module", p.Module, "payload", payloadJSON(p))
 			},
 			HandleFunc: func(p sakura.Payload) {
-				out("[INFO] Outgoing Webhook received:\n%#v", p)
+				logger.Log(sakura.LogLevelInfo, "Outgoing Webhook received", "module", p.Module, "type", p.Type, "payload", payloadJSON(p))
+			},
+			HTTPMiddlewares: []sakura.HTTPMiddleware{
+				sakura.RecoverHTTP(sakura.RecoverHTTPLog(logger)),
 			},
-			Debug: option.Debug,
+			Logger: logger,
 		}
 
 		addr := fmt.Sprintf("%s:%d", option.HostName, option.Port)
 
-		out("[INFO] start ListenAndServe. addr:[%s] path:[%s] secret:[%s]\n", addr, option.Path, option.Secret)
+		logger.Log(sakura.LogLevelInfo, "Start ListenAndServe", "addr", addr, "path", option.Path, "secret_set", option.Secret != "")
 		http.Handle(option.Path, handler)
 		return http.ListenAndServe(addr, nil)
 
@@ -180,7 +228,7 @@ func flattenErrors(errors []error) error {
 	for _, str := range errors {
 		list = append(list, str.Error())
 	}
-	return fmt.Errorf(strings.Join(list, "\n"))
+	return fmt.Errorf("%s", strings.Join(list, "\n"))
 }
 
 func isExistsFlag(source []string, target cli.Flag) bool {
@@ -0,0 +1,266 @@
+package codec
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	sakura "github.com/yamamoto-febc/sakura-iot-go"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVHeader CSV形式のヘッダ行
+//
+// seqはCSVWriterが書き込んだペイロードの通番(1から開始)で、同じペイロードの行は同じ値となる。
+// type = channelsのペイロードはチャンネル毎に1行へ展開される。
+// それ以外のペイロードは1行となり、channel/channel_typeは空、valueはペイロードのJSONとなる。
+// 値がnilのチャンネルはvalueが空となる
+var CSVHeader = []string{"seq", "module", "type", "datetime", "channel", "channel_type", "value", "channel_datetime"}
+
+const (
+	csvSeq = iota
+	csvModule
+	csvType
+	csvDatetime
+	csvChannel
+	csvChannelType
+	csvValue
+	csvChannelDatetime
+)
+
+// CSVWriter ペイロードをCSV形式で書き込む
+type CSVWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+	seq           int64
+}
+
+// NewCSVWriter 新規*CSVWriter作成
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// Write ペイロードを書き込む(初回はヘッダ行も書き込む)
+func (w *CSVWriter) Write(p sakura.Payload) error {
+	if !w.headerWritten {
+		if err := w.w.Write(CSVHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	w.seq++
+	base := []string{strconv.FormatInt(w.seq, 10), p.Module, p.Type, formatTime(p.Datetime), "", "", "", ""}
+
+	if !p.IsChannelValue() {
+		if p.Message != nil {
+			data, err := json.Marshal(p.Message)
+			if err != nil {
+				return fmt.Errorf("Failed on Marshaling payload : %s", err)
+			}
+			base[csvValue] = string(data)
+		}
+		return w.w.Write(base)
+	}
+
+	if len(p.Payload.Channels) == 0 {
+		return w.w.Write(base)
+	}
+
+	for _, c := range p.Payload.Channels {
+		row := make([]string, len(base))
+		copy(row, base)
+
+		value, err := formatValue(c.GetValue())
+		if err != nil {
+			return fmt.Errorf("Channel %d: %s", c.Channel, err)
+		}
+		row[csvChannel] = strconv.FormatInt(c.Channel, 10)
+		row[csvChannelType] = c.Type
+		row[csvValue] = value
+		row[csvChannelDatetime] = formatTime(c.Datetime)
+
+		if err := w.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush バッファリングされたデータを書き込む
+func (w *CSVWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// CSVReader CSV形式のデータからペイロードを1件ずつ読み込む
+//
+// seqが同じチャンネル行が連続する場合は1つのペイロードとしてまとめる(seqが空の行はまとめない)
+type CSVReader struct {
+	r       *csv.Reader
+	header  bool
+	pending []string
+}
+
+// NewCSVReader 新規*CSVReader作成
+func NewCSVReader(r io.Reader) *CSVReader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(CSVHeader)
+	return &CSVReader{r: cr}
+}
+
+// Read 次のペイロードを読み込む
+//
+// 全て読み込んだ場合はio.EOFを返す
+func (r *CSVReader) Read() (sakura.Payload, error) {
+	var p sakura.Payload
+
+	if !r.header {
+		header, err := r.r.Read()
+		if err != nil {
+			return p, err
+		}
+		for i, h := range CSVHeader {
+			if header[i] != h {
+				return p, fmt.Errorf("Invalid CSV header: %v", header)
+			}
+		}
+		r.header = true
+	}
+
+	row := r.pending
+	r.pending = nil
+	if row == nil {
+		var err error
+		if row, err = r.r.Read(); err != nil {
+			return p, err
+		}
+	}
+
+	datetime, err := parseTime(row[csvDatetime])
+	if err != nil {
+		return p, err
+	}
+
+	if row[csvType] != sakura.PayloadTypesChannels {
+		return parseMessageRow(row)
+	}
+
+	p = sakura.NewPayload(row[csvModule])
+	p.Datetime = datetime
+	if row[csvChannel] == "" {
+		return p, nil
+	}
+
+	for {
+		c, err := parseChannelRow(row)
+		if err != nil {
+			return p, err
+		}
+		p.Payload.Channels = append(p.Payload.Channels, c)
+
+		next, err := r.r.Read()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, err
+		}
+		if row[csvSeq] == "" || next[csvSeq] != row[csvSeq] || next[csvChannel] == "" {
+			r.pending = next
+			return p, nil
+		}
+		row = next
+	}
+}
+
+// parseChannelRow チャンネル行をChannelへ変換
+func parseChannelRow(row []string) (sakura.Channel, error) {
+	var c sakura.Channel
+
+	ch, err := strconv.ParseInt(row[csvChannel], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("Invalid channel %q", row[csvChannel])
+	}
+	datetime, err := parseTime(row[csvChannelDatetime])
+	if err != nil {
+		return c, err
+	}
+
+	c.Channel = ch
+	c.Type = row[csvChannelType]
+	c.Datetime = datetime
+	switch {
+	case row[csvValue] == "":
+		// 値がnilのチャンネル
+	case c.ChannelType() == sakura.ChannelTypeHexString:
+		c.Value = row[csvValue]
+	default:
+		c.Value = json.Number(row[csvValue])
+	}
+	return c, nil
+}
+
+// parseMessageRow type = channels以外の行をペイロードへ変換
+func parseMessageRow(row []string) (sakura.Payload, error) {
+	var p sakura.Payload
+
+	raw := struct {
+		Module   string          `json:"module"`
+		Type     string          `json:"type"`
+		Datetime string          `json:"datetime,omitempty"`
+		Payload  json.RawMessage `json:"payload,omitempty"`
+	}{
+		Module:   row[csvModule],
+		Type:     row[csvType],
+		Datetime: row[csvDatetime],
+	}
+	if row[csvValue] != "" {
+		raw.Payload = json.RawMessage(row[csvValue])
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// formatValue チャンネル値を欠損なく文字列化
+func formatValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return string(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("Unsupported value %#v", v)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid datetime %q", s)
+	}
+	return &t, nil
+}
@@ -0,0 +1,82 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	sakura "github.com/yamamoto-febc/sakura-iot-go"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+
+	payloads := testPayloads(t)
+
+	buf := new(bytes.Buffer)
+	w := NewCSVWriter(buf)
+	for _, p := range payloads {
+		assert.NoError(t, w.Write(p))
+	}
+	assert.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 1+4+1+1+1+1)
+	assert.Equal(t, lines[0], strings.Join(CSVHeader, ","))
+	assert.Equal(t, lines[2], "1,XXXXXXXXX,channels,2016-06-01T12:21:11.628907163Z,1,L,18446744073709551615,")
+
+	r := NewCSVReader(buf)
+	for _, expect := range payloads {
+		p, err := r.Read()
+		assert.NoError(t, err)
+		assert.JSONEq(t, toJSON(t, p), toJSON(t, expect))
+	}
+
+	_, err := r.Read()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestCSVSameDatetime(t *testing.T) {
+
+	// 同じモジュール/日時の別メッセージはseqで区別する
+	p1 := sakura.NewPayload("XXXXXXXXX")
+	now := time.Date(2016, 6, 1, 12, 21, 11, 0, time.UTC)
+	p1.Datetime = &now
+	p1.AddValueByInt(0, 1)
+	p2 := sakura.NewPayload("XXXXXXXXX")
+	p2.Datetime = p1.Datetime
+	p2.AddValueByInt(0, 2)
+	p2.Payload.Channels = append(p2.Payload.Channels, sakura.Channel{Channel: 1, Type: "i"})
+
+	buf := new(bytes.Buffer)
+	w := NewCSVWriter(buf)
+	assert.NoError(t, w.Write(p1))
+	assert.NoError(t, w.Write(p2))
+	assert.NoError(t, w.Flush())
+
+	r := NewCSVReader(buf)
+	for _, expect := range []sakura.Payload{p1, p2} {
+		p, err := r.Read()
+		assert.NoError(t, err)
+		assert.JSONEq(t, toJSON(t, p), toJSON(t, expect))
+	}
+
+	_, err := r.Read()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestCSVReaderErrors(t *testing.T) {
+
+	r := NewCSVReader(strings.NewReader("foo,bar,baz,a,b,c,d,e\n"))
+	_, err := r.Read()
+	assert.Error(t, err)
+
+	r = NewCSVReader(strings.NewReader(strings.Join(CSVHeader, ",") + "\n1,XXXXXXXXX,channels,invalid,0,i,1,\n"))
+	_, err = r.Read()
+	assert.Error(t, err)
+
+	r = NewCSVReader(strings.NewReader(strings.Join(CSVHeader, ",") + "\n1,XXXXXXXXX,channels,,x,i,1,\n"))
+	_, err = r.Read()
+	assert.Error(t, err)
+}
@@ -0,0 +1,7 @@
+// Package codec is archive formats for sakura.Payload
+//
+// sakura.Payloadをファイルへ保存/再読み込みするためのエンコーダ/デコーダです。
+// 以下のフォーマットを提供しています。
+//    - JSON Lines(NDJSON) : 1行1ペイロード
+//    - CSV : 1行1チャンネルに展開したフォーマット
+package codec
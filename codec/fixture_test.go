@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"encoding/json"
+	sakura "github.com/yamamoto-febc/sakura-iot-go"
+	"testing"
+)
+
+var testPayloadsJSON = []string{
+	`{"module":"XXXXXXXXX","type":"channels","datetime":"2016-06-01T12:21:11.628907163Z","payload":{"channels":[
+		{"channel":0,"type":"i","value":-1,"datetime":"2016-12-04T04:14:27.214224349Z"},
+		{"channel":1,"type":"L","value":18446744073709551615},
+		{"channel":2,"type":"d","value":1.5},
+		{"channel":3,"type":"b","value":"0f1e2d3c4b5c6b7a"}
+	]}}`,
+	`{"module":"XXXXXXXXX","type":"channels","datetime":"2016-06-01T12:21:12Z","payload":{"channels":[
+		{"channel":0,"type":"f","value":0.25}
+	]}}`,
+	`{"module":"YYYYYYYYY","type":"connection","datetime":"2016-06-01T12:21:13Z","payload":{"is_online":true}}`,
+	`{"type":"keepalive","datetime":"2016-06-11T06:24:50.643930807Z"}`,
+	`{"module":"YYYYYYYYY","type":"location","datetime":"2016-06-01T12:21:14Z","payload":{"coordinate":{"latitude":35.6809591,"longitude":139.7673068,"range_m":100}}}`,
+}
+
+func testPayloads(t *testing.T) []sakura.Payload {
+	ret := []sakura.Payload{}
+	for _, s := range testPayloadsJSON {
+		var p sakura.Payload
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+func toJSON(t *testing.T, p sakura.Payload) string {
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
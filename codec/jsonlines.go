@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	sakura "github.com/yamamoto-febc/sakura-iot-go"
+	"io"
+)
+
+// JSONLinesWriter ペイロードをJSON Lines(NDJSON)形式で書き込む
+type JSONLinesWriter struct {
+	w *bufio.Writer
+}
+
+// NewJSONLinesWriter 新規*JSONLinesWriter作成
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	return &JSONLinesWriter{w: bufio.NewWriter(w)}
+}
+
+// Write ペイロードを1行として書き込む
+func (w *JSONLinesWriter) Write(p sakura.Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Failed on Marshaling payload : %s", err)
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	return w.w.WriteByte('\n')
+}
+
+// Flush バッファリングされたデータを書き込む
+func (w *JSONLinesWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// JSONLinesReader JSON Lines(NDJSON)形式のデータからペイロードを1件ずつ読み込む
+type JSONLinesReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewJSONLinesReader 新規*JSONLinesReader作成
+func NewJSONLinesReader(r io.Reader) *JSONLinesReader {
+	return &JSONLinesReader{r: bufio.NewReader(r)}
+}
+
+// Read 次のペイロードを読み込む(空行は読み飛ばす)
+//
+// 全て読み込んだ場合はio.EOFを返す
+func (r *JSONLinesReader) Read() (sakura.Payload, error) {
+	var p sakura.Payload
+	for {
+		data, err := r.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return p, err
+		}
+		if len(data) == 0 && err == io.EOF {
+			return p, io.EOF
+		}
+		r.line++
+
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			if err == io.EOF {
+				return p, io.EOF
+			}
+			continue
+		}
+
+		if err := json.Unmarshal(data, &p); err != nil {
+			return p, fmt.Errorf("line %d: %s", r.line, err)
+		}
+		return p, nil
+	}
+}
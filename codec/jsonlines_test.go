@@ -0,0 +1,59 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+
+	payloads := testPayloads(t)
+
+	buf := new(bytes.Buffer)
+	w := NewJSONLinesWriter(buf)
+	for _, p := range payloads {
+		assert.NoError(t, w.Write(p))
+	}
+	assert.NoError(t, w.Flush())
+
+	assert.Equal(t, strings.Count(buf.String(), "\n"), len(payloads))
+
+	r := NewJSONLinesReader(buf)
+	for _, expect := range payloads {
+		p, err := r.Read()
+		assert.NoError(t, err)
+		assert.JSONEq(t, toJSON(t, p), toJSON(t, expect))
+	}
+
+	_, err := r.Read()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestJSONLinesReader(t *testing.T) {
+
+	// blank lines and a missing trailing newline
+	r := NewJSONLinesReader(strings.NewReader("\n" + testPayloadsJSON[3] + "\n\n" + testPayloadsJSON[2]))
+
+	p, err := r.Read()
+	assert.NoError(t, err)
+	assert.True(t, p.IsKeepAlive())
+
+	p, err = r.Read()
+	assert.NoError(t, err)
+	assert.True(t, p.IsConnection())
+
+	_, err = r.Read()
+	assert.Equal(t, err, io.EOF)
+
+	// invalid line
+	r = NewJSONLinesReader(strings.NewReader(testPayloadsJSON[3] + "\n{invalid\n"))
+	_, err = r.Read()
+	assert.NoError(t, err)
+	_, err = r.Read()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 2")
+	}
+}
@@ -0,0 +1,669 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// bodyBufferPool リクエストボディ読み込み用のバッファ
+var bodyBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledBufferSize プールへ戻すバッファの上限サイズ
+const maxPooledBufferSize = 64 * 1024
+
+func getBodyBuffer() *bytes.Buffer {
+	buf := bodyBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBodyBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bodyBufferPool.Put(buf)
+}
+
+// errFallback 高速デコーダが扱わない入力であることを表す
+var errFallback = fmt.Errorf("fallback to encoding/json")
+
+// decodePayload JSONからペイロードをデコード
+//
+// 一般的な形式のペイロードは専用のデコーダで処理し、エスケープを含む文字列など
+// それ以外の入力はencoding/jsonで処理する。どちらの場合も結果はjson.Unmarshalと同じとなる。
+// lazyの場合、専用のデコーダはチャンネルの値をChannel.Valueへ格納せず、未変換のまま保持する。
+// デコード結果はdataを参照しないため、dataは呼び出し後に再利用してよい。
+func decodePayload(data []byte, p *Payload, lazy bool) error {
+	d := getPayloadDecoder(data, lazy)
+	var q Payload
+	err := d.decode(&q)
+	putPayloadDecoder(d)
+	if err == nil {
+		*p = q
+		return nil
+	}
+
+	q = Payload{}
+	if err := json.Unmarshal(data, &q); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
+
+// checkUnknownFields デコード結果pに対応しないキーがdataに含まれていないか確認
+//
+// pを再度JSONへ変換した結果と比較し、存在しないキー(大文字小文字のみ異なるものを含む)があればエラーとする。
+// 値がnullのキーは省略されたものとみなす
+func checkUnknownFields(data []byte, p *Payload) error {
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	var in, out interface{}
+	if err := unmarshalUseNumber(data, &in); err != nil {
+		return err
+	}
+	if err := unmarshalUseNumber(encoded, &out); err != nil {
+		return err
+	}
+	return compareFields(in, out, "")
+}
+
+func unmarshalUseNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func compareFields(in, out interface{}, path string) error {
+	switch in := in.(type) {
+	case map[string]interface{}:
+		o, _ := out.(map[string]interface{})
+
+		keys := make([]string, 0, len(in))
+		for k := range in {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if in[k] == nil {
+				continue
+			}
+			name := k
+			if path != "" {
+				name = path + "." + k
+			}
+			v, ok := o[k]
+			if !ok {
+				return fmt.Errorf("Unknown field %q", name)
+			}
+			if err := compareFields(in[k], v, name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		o, _ := out.([]interface{})
+		for i := 0; i < len(in) && i < len(o); i++ {
+			if err := compareFields(in[i], o[i], fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// payloadDecoder Payload専用のJSONデコーダ
+//
+// デコード中は保持する文字列(module/type/チャンネルの値など)の内容のみをkeepへコピーし、
+// 最後に1つの文字列へ変換して部分参照する。デコード結果がボディ全体を参照し続けることはなく、
+// 値毎の文字列のアロケーションも発生しない。
+// チャンネルの値をjson.Number/stringとしてChannel.Value(interface{})へ格納する場合は値毎に1回のアロケーションが発生するが、
+// lazyの場合は型コード付きの部分文字列として保持するため、アロケーション回数はチャンネル数によらない
+type payloadDecoder struct {
+	data []byte
+	pos  int
+	lazy bool
+
+	keep        []byte
+	module      span
+	typ         span
+	channels    []pendingChannel
+	hasChannels bool
+}
+
+// span keep内の範囲
+type span struct {
+	start, end int
+}
+
+// pendingChannel 文字列の確定を待っているチャンネル
+type pendingChannel struct {
+	channel Channel
+	// typ 既知の型コード以外の場合の型コード
+	typ      span
+	value    span
+	kind     byte // 0: null, 'n': 数値, 's': 文字列
+	datetime time.Time
+	hasTime  bool
+}
+
+var payloadDecoderPool = sync.Pool{
+	New: func() interface{} {
+		return &payloadDecoder{}
+	},
+}
+
+func getPayloadDecoder(data []byte, lazy bool) *payloadDecoder {
+	d := payloadDecoderPool.Get().(*payloadDecoder)
+	d.reset(data, lazy)
+	return d
+}
+
+func putPayloadDecoder(d *payloadDecoder) {
+	if cap(d.keep) > maxPooledBufferSize {
+		return
+	}
+	d.reset(nil, false)
+	payloadDecoderPool.Put(d)
+}
+
+// reset dataをデコードするためにバッファを残して初期化
+func (d *payloadDecoder) reset(data []byte, lazy bool) {
+	*d = payloadDecoder{data: data, lazy: lazy, keep: d.keep[:0], channels: d.channels[:0]}
+}
+
+func (d *payloadDecoder) decode(p *Payload) error {
+	var (
+		payloadStart = -1
+		payloadEnd   = -1
+	)
+
+	err := d.object(func(key []byte) error {
+		switch string(key) {
+		case "datetime":
+			t, ok, err := d.time()
+			p.Datetime = nil
+			if ok {
+				p.Datetime = &t
+			}
+			return err
+		case "module":
+			return d.nullableString(&d.module)
+		case "type":
+			return d.nullableString(&d.typ)
+		case "payload":
+			d.ws()
+			payloadStart = d.pos
+			err := d.skip(0)
+			payloadEnd = d.pos
+			return err
+		}
+		return d.unknownKey(key, "datetime", "module", "type", "payload")
+	})
+	if err != nil {
+		return err
+	}
+
+	d.ws()
+	if d.pos != len(d.data) {
+		return errFallback
+	}
+
+	var raw []byte
+	if payloadStart >= 0 {
+		raw = d.data[payloadStart:payloadEnd]
+		if bytes.Equal(raw, []byte("null")) {
+			raw = nil
+		}
+	}
+
+	if raw != nil && string(d.kept(d.typ)) == PayloadTypesChannels {
+		d.pos = payloadStart
+		if err := d.innerPayload(&p.Payload); err != nil {
+			return err
+		}
+	}
+
+	d.finish(p)
+
+	if raw == nil || p.IsChannelValue() {
+		return nil
+	}
+	m, err := decodeMessage(p.Type, append([]byte{}, raw...))
+	if err != nil {
+		return err
+	}
+	p.Message = m
+	return nil
+}
+
+// finish keepを文字列へ変換し、保持する文字列とチャンネルの値を設定
+func (d *payloadDecoder) finish(p *Payload) {
+	s := string(d.keep)
+	p.Module = s[d.module.start:d.module.end]
+	p.Type = s[d.typ.start:d.typ.end]
+
+	if d.hasChannels {
+		p.Payload.Channels = make([]Channel, len(d.channels))
+	}
+	channels := p.Payload.Channels
+	var times []time.Time
+	for i := range d.channels {
+		if d.channels[i].hasTime {
+			times = make([]time.Time, 0, len(d.channels)-i)
+			break
+		}
+	}
+
+	for i := range d.channels {
+		pc, c := &d.channels[i], &channels[i]
+		*c = pc.channel
+		if pc.typ.end > pc.typ.start {
+			c.Type = s[pc.typ.start:pc.typ.end]
+		}
+		if pc.kind != 0 {
+			c.raw = rawValue{text: s[pc.value.start:pc.value.end], kind: pc.kind}
+			if !d.lazy {
+				c.Value, c.raw = c.value(), rawValue{}
+			}
+		}
+		if pc.hasTime {
+			times = append(times, pc.datetime)
+			c.Datetime = &times[len(times)-1]
+		}
+	}
+}
+
+// kept keep内の範囲の内容
+func (d *payloadDecoder) kept(s span) []byte {
+	return d.keep[s.start:s.end]
+}
+
+// keepRange dataの範囲をkeepへコピー
+func (d *payloadDecoder) keepRange(start, end int) span {
+	ks := len(d.keep)
+	d.keep = append(d.keep, d.data[start:end]...)
+	return span{start: ks, end: len(d.keep)}
+}
+
+func (d *payloadDecoder) innerPayload(p *InnerPayload) error {
+	return d.object(func(key []byte) error {
+		if string(key) != "channels" {
+			return d.unknownKey(key, "channels")
+		}
+
+		// チャンネルはd.channelsへ読み込み、finishで必要な数だけ確保したp.Channelsへ設定する
+		p.Channels = nil
+		d.channels = d.channels[:0]
+		d.hasChannels = false
+
+		d.ws()
+		if d.literal("null") {
+			return nil
+		}
+		if !d.consume('[') {
+			return errFallback
+		}
+
+		d.hasChannels = true
+		d.ws()
+		if d.consume(']') {
+			return nil
+		}
+		for {
+			d.ws()
+			d.channels = append(d.channels, pendingChannel{})
+			if err := d.channel(&d.channels[len(d.channels)-1]); err != nil {
+				return err
+			}
+
+			d.ws()
+			if d.consume(']') {
+				return nil
+			}
+			if !d.consume(',') {
+				return errFallback
+			}
+		}
+	})
+}
+
+func (d *payloadDecoder) channel(pc *pendingChannel) error {
+	c := &pc.channel
+	return d.object(func(key []byte) error {
+		switch string(key) {
+		case "channel":
+			n, err := d.number()
+			if err != nil {
+				return err
+			}
+			c.Channel, err = parseInt64(d.data[n.start:n.end])
+			return err
+		case "type":
+			d.ws()
+			if d.literal("null") {
+				return nil
+			}
+			start, end, err := d.stringRange()
+			if err != nil {
+				return err
+			}
+			c.Type, pc.typ = "", span{}
+			if t, ok := knownChannelType(d.data[start:end]); ok {
+				c.Type = string(t)
+				return nil
+			}
+			pc.typ = d.keepRange(start, end)
+			return nil
+		case "value":
+			return d.value(pc)
+		case "datetime":
+			t, ok, err := d.time()
+			pc.datetime, pc.hasTime = t, ok
+			return err
+		}
+		return d.unknownKey(key, "channel", "type", "value", "datetime")
+	})
+}
+
+// value 値の範囲と種類(数値/文字列/null)を読み込む
+func (d *payloadDecoder) value(pc *pendingChannel) error {
+	d.ws()
+	if d.pos >= len(d.data) {
+		return errFallback
+	}
+	switch b := d.data[d.pos]; {
+	case b == '"':
+		start, end, err := d.stringRange()
+		pc.value, pc.kind = d.keepRange(start, end), 's'
+		return err
+	case b == '-' || ('0' <= b && b <= '9'):
+		n, err := d.number()
+		if err != nil {
+			return err
+		}
+		pc.value, pc.kind = d.keepRange(n.start, n.end), 'n'
+		return nil
+	case d.literal("null"):
+		pc.value, pc.kind = span{}, 0
+		return nil
+	}
+	return errFallback
+}
+
+// object オブジェクトを読み込み、キー毎にfを呼ぶ
+func (d *payloadDecoder) object(f func(key []byte) error) error {
+	d.ws()
+	if !d.consume('{') {
+		return errFallback
+	}
+	d.ws()
+	if d.consume('}') {
+		return nil
+	}
+	for {
+		d.ws()
+		key, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		d.ws()
+		if !d.consume(':') {
+			return errFallback
+		}
+		if err := f(key); err != nil {
+			return err
+		}
+		d.ws()
+		if d.consume('}') {
+			return nil
+		}
+		if !d.consume(',') {
+			return errFallback
+		}
+	}
+}
+
+// unknownKey 大文字小文字の違いのみのキーはencoding/jsonに任せ、それ以外は読み飛ばす
+func (d *payloadDecoder) unknownKey(key []byte, known ...string) error {
+	for _, k := range known {
+		if bytes.EqualFold(key, []byte(k)) {
+			return errFallback
+		}
+	}
+	return d.skip(0)
+}
+
+func (d *payloadDecoder) ws() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *payloadDecoder) consume(b byte) bool {
+	if d.pos < len(d.data) && d.data[d.pos] == b {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *payloadDecoder) literal(s string) bool {
+	if bytes.HasPrefix(d.data[d.pos:], []byte(s)) {
+		d.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// rawString エスケープ/制御文字/不正なUTF-8を含まない文字列の範囲を返す
+func (d *payloadDecoder) rawString() ([]byte, error) {
+	start, end, err := d.stringRange()
+	if err != nil {
+		return nil, err
+	}
+	return d.data[start:end], nil
+}
+
+func (d *payloadDecoder) stringRange() (int, int, error) {
+	if !d.consume('"') {
+		return 0, 0, errFallback
+	}
+	start := d.pos
+	ascii := true
+	for d.pos < len(d.data) {
+		b := d.data[d.pos]
+		switch {
+		case b == '"':
+			end := d.pos
+			d.pos++
+			if !ascii && !utf8.Valid(d.data[start:end]) {
+				return 0, 0, errFallback
+			}
+			return start, end, nil
+		case b == '\\' || b < 0x20:
+			return 0, 0, errFallback
+		case b >= utf8.RuneSelf:
+			ascii = false
+		}
+		d.pos++
+	}
+	return 0, 0, errFallback
+}
+
+// nullableString 文字列をkeepへコピーしてdstへ設定する(nullの場合はencoding/jsonと同じく変更しない)
+func (d *payloadDecoder) nullableString(dst *span) error {
+	d.ws()
+	if d.literal("null") {
+		return nil
+	}
+	start, end, err := d.stringRange()
+	if err != nil {
+		return err
+	}
+	*dst = d.keepRange(start, end)
+	return nil
+}
+
+// time 日時を読み込む(nullの場合はfalse)
+func (d *payloadDecoder) time() (time.Time, bool, error) {
+	var t time.Time
+	d.ws()
+	if d.literal("null") {
+		return t, false, nil
+	}
+	start, end, err := d.stringRange()
+	if err != nil {
+		return t, false, err
+	}
+	// 引用符を含めてtime.Timeへ渡し、encoding/jsonと同じ規則で解釈する
+	if err := t.UnmarshalJSON(d.data[start-1 : end+1]); err != nil {
+		return t, false, errFallback
+	}
+	return t, true, nil
+}
+
+// number JSONの数値の文法に従う数値リテラルのdata内の範囲を返す
+func (d *payloadDecoder) number() (span, error) {
+	d.ws()
+	start := d.pos
+	d.consume('-')
+
+	digits := func() int {
+		n := 0
+		for d.pos < len(d.data) && '0' <= d.data[d.pos] && d.data[d.pos] <= '9' {
+			d.pos++
+			n++
+		}
+		return n
+	}
+
+	if d.consume('0') {
+		// 先頭の0の後に数字は続かない
+	} else if digits() == 0 {
+		return span{}, errFallback
+	}
+	if d.consume('.') && digits() == 0 {
+		return span{}, errFallback
+	}
+	if d.consume('e') || d.consume('E') {
+		if !d.consume('+') {
+			d.consume('-')
+		}
+		if digits() == 0 {
+			return span{}, errFallback
+		}
+	}
+	return span{start: start, end: d.pos}, nil
+}
+
+// skip 値を読み飛ばす
+func (d *payloadDecoder) skip(depth int) error {
+	if depth > maxBinaryDepth {
+		return errFallback
+	}
+
+	d.ws()
+	if d.pos >= len(d.data) {
+		return errFallback
+	}
+
+	switch b := d.data[d.pos]; {
+	case b == '"':
+		_, _, err := d.stringRange()
+		return err
+	case b == '-' || ('0' <= b && b <= '9'):
+		_, err := d.number()
+		return err
+	case b == '{':
+		return d.object(func(key []byte) error {
+			return d.skip(depth + 1)
+		})
+	case b == '[':
+		d.pos++
+		d.ws()
+		if d.consume(']') {
+			return nil
+		}
+		for {
+			if err := d.skip(depth + 1); err != nil {
+				return err
+			}
+			d.ws()
+			if d.consume(']') {
+				return nil
+			}
+			if !d.consume(',') {
+				return errFallback
+			}
+		}
+	case d.literal("null"), d.literal("true"), d.literal("false"):
+		return nil
+	}
+	return errFallback
+}
+
+// knownChannelType 既知の型コードの定数を返す
+func knownChannelType(b []byte) (ChannelType, bool) {
+	switch string(b) {
+	case string(ChannelTypeInt):
+		return ChannelTypeInt, true
+	case string(ChannelTypeUint):
+		return ChannelTypeUint, true
+	case string(ChannelTypeInt64):
+		return ChannelTypeInt64, true
+	case string(ChannelTypeUint64):
+		return ChannelTypeUint64, true
+	case string(ChannelTypeFloat):
+		return ChannelTypeFloat, true
+	case string(ChannelTypeDouble):
+		return ChannelTypeDouble, true
+	case string(ChannelTypeHexString):
+		return ChannelTypeHexString, true
+	}
+	return "", false
+}
+
+// parseInt64 整数のみからなる数値リテラルをint64へ変換(それ以外はerrFallback)
+func parseInt64(b []byte) (int64, error) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return 0, errFallback
+	}
+
+	var n uint64
+	for _, c := range b {
+		if c < '0' || '9' < c || n > (1<<63)/10 {
+			return 0, errFallback
+		}
+		n = n*10 + uint64(c-'0')
+	}
+
+	switch {
+	case neg && n <= 1<<63:
+		return -int64(n), nil
+	case !neg && n < 1<<63:
+		return int64(n), nil
+	}
+	return 0, errFallback
+}
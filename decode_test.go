@@ -0,0 +1,211 @@
+package sakura
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var decodeTestCases = []string{
+	payloadTestJSONInt,
+	payloadTestJSONHexString,
+	keepAliveTestJSON,
+	connectionTestJSON,
+	locationTestJSON,
+	unknownTestJSON,
+	`{"module":"m","type":"channels","payload":{"channels":[]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":null}}`,
+	`{"module":"m","type":"channels","payload":null}`,
+	`{"payload":{"channels":[{"channel":1,"type":"d","value":-1.5e-3}]},"type":"channels"}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":1,"type":"l","value":18446744073709551615}]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":1,"type":"i","value":null,"extra":[1,{"a":true}]}]}}`,
+	`{"module":"mA","type":"channels","payload":{"channels":[]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":-9223372036854775808,"type":"x","value":"v"},{"channel":9223372036854775807,"type":"","value":1}]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":9223372036854775808,"type":"i","value":1}]}}`,
+	`{"module":"m","module":null,"type":"channels","payload":{"channels":[{"channel":1,"type":"x","type":null,"value":1,"value":null,"datetime":"2016-12-04T04:14:27+09:00"},{"channel":2,"type":"b","value":"0f1e2d3c4b5c6b7a"}]}}`,
+	`{"module":"モジュール","type":"channels","payload":{"channels":[]}}`,
+	`{"Module":"m","TYPE":"channels","payload":{"Channels":[{"Channel":1,"Type":"i","Value":1}]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":1,"type":"i","value":true}]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":1.5,"type":"i","value":1}]}}`,
+	`{"module":"m","type":"channels","payload":{"channels":[{"channel":1,"type":"i","value":01}]}}`,
+	`{"module":"m","type":"channels","datetime":"invalid"}`,
+	`{"module":"m","type":"channels"} {}`,
+	`{"module":"m","type":"channels"`,
+	`[]`,
+	``,
+}
+
+func TestDecodePayload(t *testing.T) {
+	for _, src := range decodeTestCases {
+		var expected, actual Payload
+		expectedErr := json.Unmarshal([]byte(src), &expected)
+		actualErr := decodePayload([]byte(src), &actual, false)
+
+		assert.Equal(t, actualErr != nil, expectedErr != nil, src)
+		if expectedErr == nil {
+			assert.True(t, reflect.DeepEqual(actual, expected), "%s\nactual:   %#v\nexpected: %#v", src, actual, expected)
+		}
+	}
+}
+
+func TestDecodePayloadReuseBuffer(t *testing.T) {
+	data := []byte(connectionTestJSON)
+
+	var payload Payload
+	err := decodePayload(data, &payload, false)
+	assert.NoError(t, err)
+
+	// デコード結果がバッファを参照していないこと
+	for i := range data {
+		data[i] = ' '
+	}
+	conn, ok := payload.Connection()
+	assert.True(t, ok)
+	assert.Equal(t, conn.IsOnline, true)
+	assert.Equal(t, payload.Type, PayloadTypesConnection)
+}
+
+// benchmarkPayload 16チャンネルを含むペイロード
+var benchmarkPayload = channelsTestPayload(16)
+
+// channelsTestPayload n個のチャンネルを含むペイロード
+func channelsTestPayload(n int) []byte {
+	channels := make([]string, n)
+	for i := range channels {
+		channels[i] = fmt.Sprintf(
+			`{"channel":%d,"type":"i","value":%d,"datetime":"2016-12-04T04:14:27.214224349Z"}`,
+			i, i*100,
+		)
+	}
+	return []byte(fmt.Sprintf(payloadTestJSONTemplate, strings.Join(channels, ",")))
+}
+
+func TestDecodePayloadLazyValue(t *testing.T) {
+	for _, src := range decodeTestCases {
+		var expected, actual Payload
+		expectedErr := decodePayload([]byte(src), &expected, false)
+		actualErr := decodePayload([]byte(src), &actual, true)
+
+		assert.Equal(t, actualErr != nil, expectedErr != nil, src)
+		if expectedErr != nil {
+			continue
+		}
+
+		// 値はGetValue()で生成され、Valueへ設定される
+		expectedJSON, err := json.Marshal(expected)
+		assert.NoError(t, err)
+		actualJSON, err := json.Marshal(actual)
+		assert.NoError(t, err)
+		assert.Equal(t, string(actualJSON), string(expectedJSON), src)
+
+		for i := range actual.Payload.Channels {
+			c := &actual.Payload.Channels[i]
+			assert.Equal(t, c.GetValue(), expected.Payload.Channels[i].Value, src)
+			assert.Equal(t, c.Value, expected.Payload.Channels[i].Value, src)
+		}
+	}
+
+	var p Payload
+	err := decodePayload([]byte(payloadTestJSONInt), &p, true)
+	assert.NoError(t, err)
+	c := p.Payload.Channels[0]
+	assert.Nil(t, c.Value)
+	v, err := c.GetInt()
+	assert.NoError(t, err)
+	assert.Equal(t, v, int32(1))
+
+	// アロケーション回数はチャンネル数によらない(sync.Poolの影響を受けないようデコーダを直接利用する)
+	d := &payloadDecoder{}
+	allocs := func(data []byte) float64 {
+		return testing.AllocsPerRun(100, func() {
+			d.reset(data, true)
+			var p Payload
+			if err := d.decode(&p); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	assert.Equal(t, allocs(channelsTestPayload(32)), allocs(channelsTestPayload(2)))
+}
+
+func BenchmarkDecodePayloadEncodingJSON(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkPayload)))
+	for i := 0; i < b.N; i++ {
+		var p Payload
+		if err := json.Unmarshal(benchmarkPayload, &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecodePayload(b *testing.B, lazy bool) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkPayload)))
+	for i := 0; i < b.N; i++ {
+		var p Payload
+		if err := decodePayload(benchmarkPayload, &p, lazy); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePayload(b *testing.B) {
+	benchmarkDecodePayload(b, false)
+}
+
+func BenchmarkDecodePayloadLazyValue(b *testing.B) {
+	benchmarkDecodePayload(b, true)
+}
+
+// legacyWebhookHandler 従来の読み込み/デコード処理
+type legacyWebhookHandler struct {
+	HandleFunc WebhookHandlerFunc
+}
+
+func (h *legacyWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bufbody := new(bytes.Buffer)
+	bufbody.ReadFrom(r.Body)
+
+	var payload Payload
+	if err := json.Unmarshal(bufbody.Bytes(), &payload); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	h.HandleFunc(payload)
+	w.WriteHeader(200)
+}
+
+func benchmarkServeHTTP(b *testing.B, h http.Handler) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkPayload)))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", nil)
+	for i := 0; i < b.N; i++ {
+		req.Body = ioutil.NopCloser(bytes.NewReader(benchmarkPayload))
+		h.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkServeHTTPEncodingJSON(b *testing.B) {
+	benchmarkServeHTTP(b, &legacyWebhookHandler{HandleFunc: func(Payload) {}})
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	benchmarkServeHTTP(b, &WebhookHandler{HandleFunc: func(Payload) {}})
+}
+
+func BenchmarkServeHTTPLazyValue(b *testing.B) {
+	benchmarkServeHTTP(b, &WebhookHandler{
+		LazyValue: true,
+		Handler:   HandlerFunc(func(context.Context, Payload) error { return nil }),
+	})
+}
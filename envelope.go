@@ -0,0 +1,65 @@
+package sakura
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Envelope 受信したメッセージとリクエストの情報
+//
+// WebhookHandlerはHandler/Middlewareへ渡すcontextにEnvelopeを設定する。
+// RawBodyは署名された受信時のボディそのものであるため、監査用の保存や転送に利用できる
+// (既定で設定される。WebhookHandler.DiscardRawBodyを指定した場合はnil)
+//
+//	func(ctx context.Context, p sakura.Payload) error {
+//		if e, ok := sakura.EnvelopeFromContext(ctx); ok {
+//			archive(e.RawBody, e.Signature)
+//		}
+//		...
+//	}
+type Envelope struct {
+	Payload Payload
+	// RawBody 受信したリクエストボディ(WebhookHandler.DiscardRawBodyを指定した場合はnil)
+	RawBody []byte
+	// Signature 署名ヘッダの値(署名ヘッダが無い場合は空)
+	Signature string
+	// SecretName 署名を検証できたシークレットの名前(検証していない場合は空)
+	SecretName string
+	// Header リクエストヘッダ(変更しないこと)
+	Header     http.Header
+	RemoteAddr string
+	Path       string
+	// ReceivedAt リクエストを受信した時刻
+	ReceivedAt time.Time
+}
+
+type envelopeKey struct{}
+
+// WithEnvelope Envelopeを設定したcontextを返す
+//
+// net/http以外の経路で受信したメッセージをHandlerへ渡す場合に利用する
+func WithEnvelope(ctx context.Context, e *Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, e)
+}
+
+// envelopeContext Envelopeを保持するcontext
+//
+// WebhookHandlerがリクエスト毎にEnvelopeとcontextを1度のアロケーションで作成するために利用する
+type envelopeContext struct {
+	context.Context
+	envelope Envelope
+}
+
+func (c *envelopeContext) Value(key interface{}) interface{} {
+	if key == (envelopeKey{}) {
+		return &c.envelope
+	}
+	return c.Context.Value(key)
+}
+
+// EnvelopeFromContext contextに設定されたEnvelopeを取得
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	e, ok := ctx.Value(envelopeKey{}).(*Envelope)
+	return e, ok && e != nil
+}
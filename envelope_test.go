@@ -0,0 +1,126 @@
+package sakura
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yamamoto-febc/sakura-iot-go/signature"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandlerEnvelope(t *testing.T) {
+
+	// 既定の設定でRawBodyを含めて設定される
+	var envelope *Envelope
+	h := &WebhookHandler{
+		Secrets: []WebhookSecret{{Name: "current", Value: "secret"}},
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			e, ok := EnvelopeFromContext(ctx)
+			assert.True(t, ok)
+			envelope = e
+			return nil
+		}),
+	}
+
+	sig, err := signature.Sign([]byte("secret"), []byte(payloadTestJSONInt))
+	assert.NoError(t, err)
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(payloadTestJSONInt))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sakura-Signature", sig)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	before := time.Now()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 200)
+
+	assert.Equal(t, string(envelope.RawBody), payloadTestJSONInt)
+	assert.Equal(t, envelope.Signature, sig)
+	assert.Equal(t, envelope.SecretName, "current")
+	assert.Equal(t, envelope.Header.Get("Content-Type"), "application/json")
+	assert.Equal(t, envelope.RemoteAddr, "192.0.2.1:1234")
+	assert.Equal(t, envelope.Path, "/webhook")
+	assert.Equal(t, envelope.Payload.Module, "XXXXXXXXX")
+	assert.False(t, envelope.ReceivedAt.Before(before))
+}
+
+func TestWebhookHandlerEnvelopeAsync(t *testing.T) {
+
+	envelopes := make(chan *Envelope, 2)
+	h := &WebhookHandler{
+		HandleFunc:    func(p Payload) {},
+		ConnectedFunc: func(p Payload) {},
+		Middlewares: []Middleware{
+			func(next Handler) Handler {
+				return HandlerFunc(func(ctx context.Context, p Payload) error {
+					e, _ := EnvelopeFromContext(ctx)
+					envelopes <- e
+					return next.Handle(ctx, p)
+				})
+			},
+		},
+	}
+
+	postWebhook(h, payloadTestJSONInt)
+	first := <-envelopes
+	postWebhook(h, connectionTestJSON)
+	second := <-envelopes
+
+	// 受信バッファが再利用されてもRawBodyは変わらない
+	assert.Equal(t, string(first.RawBody), payloadTestJSONInt)
+	assert.Equal(t, string(second.RawBody), connectionTestJSON)
+	assert.Equal(t, first.Signature, "")
+	assert.Equal(t, first.SecretName, "")
+
+	// DiscardRawBodyを指定した場合はRawBodyを設定しない
+	h.DiscardRawBody = true
+	postWebhook(h, payloadTestJSONInt)
+	third := <-envelopes
+	assert.Nil(t, third.RawBody)
+	assert.Equal(t, third.Payload.Module, "XXXXXXXXX")
+}
+
+func TestWebhookHandlerAsyncContext(t *testing.T) {
+
+	release := make(chan struct{})
+	result := make(chan error, 1)
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {},
+		Middlewares: []Middleware{
+			func(next Handler) Handler {
+				return HandlerFunc(func(ctx context.Context, p Payload) error {
+					<-release
+					// リクエストの処理完了後もキャンセルされず、Envelopeは参照できる
+					_, ok := EnvelopeFromContext(ctx)
+					assert.True(t, ok)
+					assert.Nil(t, ctx.Done())
+					result <- ctx.Err()
+					return next.Handle(ctx, p)
+				})
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/", strings.NewReader(payloadTestJSONInt)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 200)
+	cancel()
+	close(release)
+
+	assert.NoError(t, <-result)
+}
+
+func TestEnvelopeFromContext(t *testing.T) {
+
+	_, ok := EnvelopeFromContext(context.Background())
+	assert.False(t, ok)
+
+	e := &Envelope{RawBody: []byte(payloadTestJSONInt)}
+	got, ok := EnvelopeFromContext(WithEnvelope(context.Background(), e))
+	assert.True(t, ok)
+	assert.Equal(t, got, e)
+}
@@ -0,0 +1,99 @@
+package sakura
+
+import (
+	"context"
+	"net/http"
+)
+
+// Handler is interface to handle received payload
+//
+// Handle is called synchronously from WebhookHandler.ServeHTTP. When it returns error,
+// WebhookHandler responds error status so that Sakura-IoT-platform retries the delivery.
+type Handler interface {
+	Handle(ctx context.Context, p Payload) error
+}
+
+// HandlerFunc is adapter to use ordinary function as Handler
+type HandlerFunc func(ctx context.Context, p Payload) error
+
+// Handle calls f(ctx, p)
+func (f HandlerFunc) Handle(ctx context.Context, p Payload) error {
+	return f(ctx, p)
+}
+
+// Handle implements Handler interface. It calls f(p) and always returns nil
+func (f WebhookHandlerFunc) Handle(ctx context.Context, p Payload) error {
+	f(p)
+	return nil
+}
+
+// StatusError is error with HTTP status code to respond
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+// NewStatusError returns error that WebhookHandler responds with HTTP status code
+//
+// code must be in 100-999 (WebhookHandler responds 500 otherwise)
+func NewStatusError(code int, err error) error {
+	return &StatusError{Code: code, Err: err}
+}
+
+// Error implements error interface
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns underlying error
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultErrorStatus returns HTTP status code for error returned from Handler
+//
+//   - *StatusError : its Code (500 if it is not in 100-999)
+//   - context.DeadlineExceeded / context.Canceled : 503
+//   - others : 500
+func DefaultErrorStatus(err error) int {
+	for e := err; e != nil; e = unwrapError(e) {
+		if se, ok := e.(*StatusError); ok {
+			if !validStatus(se.Code) {
+				return http.StatusInternalServerError
+			}
+			return se.Code
+		}
+	}
+	if isError(err, context.DeadlineExceeded) || isError(err, context.Canceled) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// validStatus reports whether code can be passed to http.ResponseWriter.WriteHeader
+func validStatus(code int) bool {
+	return 100 <= code && code <= 999
+}
+
+// unwrapError returns underlying error if err has Unwrap method, otherwise nil
+func unwrapError(err error) error {
+	if u, ok := err.(interface {
+		Unwrap() error
+	}); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
+
+// isError reports whether err or its underlying errors (by Unwrap method) is target
+func isError(err, target error) bool {
+	for e := err; e != nil; e = unwrapError(e) {
+		if e == target {
+			return true
+		}
+	}
+	return false
+}
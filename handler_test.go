@@ -0,0 +1,122 @@
+package sakura
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandlerHandler(t *testing.T) {
+
+	var received []Payload
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			received = append(received, p)
+			return nil
+		}),
+		HandleFunc: func(p Payload) { t.Fatal("HandleFunc must not be called") },
+	}
+
+	// Handlerは同期的に呼ばれる
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	assert.Len(t, received, 1)
+	assert.Equal(t, received[0].Type, PayloadTypesChannels)
+
+	w = postWebhook(h, keepAliveTestJSON)
+	assert.Equal(t, w.Code, 200)
+	assert.Len(t, received, 2)
+	assert.Equal(t, received[1].Type, PayloadTypesKeepAlive)
+}
+
+func TestWebhookHandlerHandlerContext(t *testing.T) {
+	type ctxKey struct{}
+
+	var value interface{}
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			value = ctx.Value(ctxKey{})
+			return nil
+		}),
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(payloadTestJSONInt))
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "value"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, value, "value")
+}
+
+func TestWebhookHandlerHandlerError(t *testing.T) {
+
+	expects := []struct {
+		err    error
+		status int
+	}{
+		{err: fmt.Errorf("database is down"), status: 500},
+		{err: NewStatusError(http.StatusServiceUnavailable, fmt.Errorf("busy")), status: 503},
+		{err: &DetailError{Err: NewStatusError(http.StatusConflict, nil), Detail: "wrapped"}, status: 409},
+		{err: context.DeadlineExceeded, status: 503},
+		{err: NewStatusError(0, fmt.Errorf("invalid status")), status: 500},
+		{err: NewStatusError(1000, nil), status: 500},
+	}
+
+	for _, expect := range expects {
+		err := expect.err
+		h := &WebhookHandler{
+			Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+				return err
+			}),
+		}
+		w := postWebhook(h, payloadTestJSONInt)
+		assert.Equal(t, w.Code, expect.status, err.Error())
+	}
+}
+
+func TestWebhookHandlerErrorStatus(t *testing.T) {
+
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			return fmt.Errorf("error")
+		}),
+		ErrorStatus: func(err error) int {
+			return http.StatusBadGateway
+		},
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 502)
+
+	// 範囲外のステータスは500とする
+	h.ErrorStatus = func(err error) int {
+		return 0
+	}
+	w = postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+}
+
+func TestWebhookHandlerFuncAdapter(t *testing.T) {
+
+	var received Payload
+	var handler Handler = WebhookHandlerFunc(func(p Payload) { received = p })
+
+	err := handler.Handle(context.Background(), NewPayload("module"))
+	assert.NoError(t, err)
+	assert.Equal(t, received.Module, "module")
+}
+
+func TestStatusError(t *testing.T) {
+
+	err := NewStatusError(http.StatusTooManyRequests, nil)
+	assert.Equal(t, err.Error(), "Too Many Requests")
+
+	err = NewStatusError(http.StatusTooManyRequests, fmt.Errorf("slow down"))
+	assert.Equal(t, err.Error(), "slow down")
+	assert.Equal(t, DefaultErrorStatus(err), 429)
+}
@@ -0,0 +1,200 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel ログの重要度
+type LogLevel int
+
+const (
+	// LogLevelDebug デバッグ用の詳細な情報
+	LogLevelDebug LogLevel = iota
+	// LogLevelInfo 通常の動作に関する情報
+	LogLevelInfo
+	// LogLevelWarn 処理に失敗したメッセージなど
+	LogLevelWarn
+	// LogLevelError panicなど
+	LogLevelError
+)
+
+// String レベル名
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel レベル名(大文字小文字は区別しない)からLogLevelを取得
+func ParseLogLevel(s string) (LogLevel, error) {
+	for l := LogLevelDebug; l <= LogLevelError; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q", s)
+}
+
+// Logger レベルとキー/値の組を持つ構造化ログの出力先
+//
+// keyvalsはキー(string)と値を交互に並べたもの。
+// Enabled(LogLevel) boolを実装すると、出力しないレベルのログの組み立てを省略できる
+//
+//	logger.Log(sakura.LogLevelInfo, "Request rejected", "status", 403, "reason", err)
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// logEnabled loggerが指定レベルのログを出力するか判定
+//
+// Enabled(LogLevel) boolを実装していないLoggerは常に出力するものとみなす
+func logEnabled(logger Logger, level LogLevel) bool {
+	if l, ok := logger.(interface {
+		Enabled(LogLevel) bool
+	}); ok {
+		return l.Enabled(level)
+	}
+	return true
+}
+
+// nopLogger 何も出力しないLogger
+type nopLogger struct{}
+
+func (nopLogger) Log(LogLevel, string, ...interface{}) {}
+
+func (nopLogger) Enabled(LogLevel) bool { return false }
+
+// NopLogger 何も出力しないLogger
+var NopLogger Logger = nopLogger{}
+
+// StdLogger 標準のlogパッケージへ"[LEVEL] msg key=value ..."の形式で出力するLogger
+type StdLogger struct {
+	// Logger 出力先(nilの場合はlogパッケージの標準のLogger)
+	Logger *log.Logger
+	// Level 出力する最低のレベル
+	Level LogLevel
+}
+
+// NewStdLogger 新規*StdLogger作成
+func NewStdLogger(l *log.Logger, level LogLevel) *StdLogger {
+	return &StdLogger{Logger: l, Level: level}
+}
+
+// Enabled 指定レベルのログを出力するか判定
+func (l *StdLogger) Enabled(level LogLevel) bool {
+	return level >= l.Level
+}
+
+// Log Loggerインターフェースの実装
+func (l *StdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[%s] %s", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, value := logKeyValue(keyvals, i)
+		s := fmt.Sprint(value)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, s)
+	}
+
+	if l.Logger == nil {
+		log.Print(b.String())
+		return
+	}
+	l.Logger.Print(b.String())
+}
+
+// JSONLogger 1行1オブジェクトのJSONで出力するLogger(並行利用可能)
+//
+// ゼロ値の場合はos.Stderrへ出力する
+//
+//	{"time":"2016-06-01T12:21:11.628907163Z","level":"INFO","msg":"Request rejected","status":403}
+type JSONLogger struct {
+	// Level 出力する最低のレベル
+	Level LogLevel
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger 新規*JSONLogger作成(wがnilの場合はos.Stderr)
+func NewJSONLogger(w io.Writer, level LogLevel) *JSONLogger {
+	return &JSONLogger{w: w, Level: level}
+}
+
+// Enabled 指定レベルのログを出力するか判定
+func (l *JSONLogger) Enabled(level LogLevel) bool {
+	return level >= l.Level
+}
+
+// Log Loggerインターフェースの実装
+//
+// time/level/msgと同じキーはそれらで上書きされる。errorは文字列として出力する
+func (l *JSONLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(keyvals)/2+3)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, value := logKeyValue(keyvals, i)
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case fmt.Stringer:
+			value = v.String()
+		}
+		entry[key] = value
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"time":  entry["time"],
+			"level": entry["level"],
+			"msg":   msg,
+			"error": fmt.Sprintf("Failed on marshaling log fields: %s", err),
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.w
+	if w == nil {
+		w = os.Stderr
+	}
+	w.Write(append(data, '\n'))
+}
+
+// logKeyValue keyvals[i]をキー、keyvals[i+1]を値として返す(値が無い場合はnil)
+func logKeyValue(keyvals []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(keyvals[i])
+	if i+1 < len(keyvals) {
+		return key, keyvals[i+1]
+	}
+	return key, nil
+}
@@ -0,0 +1,129 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logEntry testLoggerが記録したログ
+type logEntry struct {
+	level   LogLevel
+	msg     string
+	keyvals []interface{}
+}
+
+// testLogger 受け取ったログを記録するLogger
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, keyvals: keyvals})
+}
+
+func TestParseLogLevel(t *testing.T) {
+
+	for _, s := range []string{"debug", "INFO", "Warn", "error"} {
+		level, err := ParseLogLevel(s)
+		assert.NoError(t, err)
+		assert.Equal(t, level.String(), strings.ToUpper(s))
+	}
+
+	_, err := ParseLogLevel("trace")
+	assert.Error(t, err)
+}
+
+func TestStdLogger(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	logger := NewStdLogger(log.New(buf, "", 0), LogLevelInfo)
+
+	logger.Log(LogLevelDebug, "Ignored")
+	logger.Log(LogLevelInfo, "Request rejected", "status", 403, "reason", fmt.Errorf("Invalid signature"), "empty", "")
+	logger.Log(LogLevelError, "Odd", "key")
+
+	assert.False(t, logEnabled(logger, LogLevelDebug))
+	assert.True(t, logEnabled(logger, LogLevelWarn))
+	assert.Equal(t, buf.String(), "[INFO] Request rejected status=403 reason=\"Invalid signature\" empty=\"\"\n"+
+		"[ERROR] Odd key=<nil>\n")
+}
+
+func TestJSONLogger(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	logger := NewJSONLogger(buf, LogLevelWarn)
+
+	logger.Log(LogLevelInfo, "Ignored")
+	logger.Log(LogLevelWarn, "Failed on handling message", "status", 503, "error", fmt.Errorf("failed"), "level", "overwritten")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	err := json.Unmarshal([]byte(lines[0]), &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, entry["level"], "WARN")
+	assert.Equal(t, entry["msg"], "Failed on handling message")
+	assert.Equal(t, entry["status"], float64(503))
+	assert.Equal(t, entry["error"], "failed")
+	assert.NotEmpty(t, entry["time"])
+}
+
+func TestJSONLoggerZeroValue(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "sakura-logger")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	stderr := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = stderr }()
+
+	// ゼロ値の場合はos.Stderrへ出力する
+	var logger JSONLogger
+	logger.Log(LogLevelInfo, "Request rejected", "status", 403)
+
+	data, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"msg":"Request rejected"`)
+}
+
+func TestWebhookHandlerLogger(t *testing.T) {
+
+	logger := &testLogger{}
+	h := &WebhookHandler{
+		Secret: "secret",
+		Logger: logger,
+	}
+
+	w := postSignedWebhook(h, "/", "invalid", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+
+	var rejected *logEntry
+	for i := range logger.entries {
+		if logger.entries[i].msg == "Request rejected" {
+			rejected = &logger.entries[i]
+		}
+	}
+	if assert.NotNil(t, rejected) {
+		assert.Equal(t, rejected.level, LogLevelInfo)
+		assert.Equal(t, rejected.keyvals[0:3], []interface{}{"status", 403, "reason"})
+		assert.True(t, isError(rejected.keyvals[3].(error), ErrInvalidSignature))
+	}
+
+	// Debugのみ指定された場合は標準のlogパッケージへ出力する
+	assert.IsType(t, (&WebhookHandler{Debug: true}).logger(), &StdLogger{})
+	assert.Equal(t, (&WebhookHandler{}).logger(), NopLogger)
+}
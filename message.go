@@ -0,0 +1,148 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ConnectionMessage モジュール接続時メッセージ(type = connection)のペイロード
+type ConnectionMessage struct {
+	IsOnline bool `json:"is_online"`
+}
+
+// LocationMessage 位置情報メッセージ(type = location)のペイロード
+type LocationMessage struct {
+	Coordinate *Coordinate `json:"coordinate"`
+}
+
+// Coordinate 位置情報
+type Coordinate struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	RangeM    float64 `json:"range_m"`
+}
+
+// MessageDecoder type = channels以外のペイロードをデコードする関数
+type MessageDecoder func(data json.RawMessage) (interface{}, error)
+
+var (
+	messageDecodersMu sync.RWMutex
+	messageDecoders   = map[string]MessageDecoder{
+		PayloadTypesConnection: func(data json.RawMessage) (interface{}, error) {
+			m := &ConnectionMessage{}
+			err := json.Unmarshal(data, m)
+			return m, err
+		},
+		PayloadTypesLocation: func(data json.RawMessage) (interface{}, error) {
+			m := &LocationMessage{}
+			err := json.Unmarshal(data, m)
+			return m, err
+		},
+	}
+)
+
+// RegisterMessageType メッセージタイプとデコーダを登録
+//
+// 登録済みのタイプを指定した場合はデコーダを置き換える。
+// type = channelsは常にInnerPayloadへデコードされるため登録できない
+func RegisterMessageType(typ string, decoder MessageDecoder) {
+	if typ == "" || typ == PayloadTypesChannels {
+		panic(fmt.Sprintf("sakura: can't register message type %q", typ))
+	}
+	if decoder == nil {
+		panic("sakura: nil MessageDecoder")
+	}
+
+	messageDecodersMu.Lock()
+	defer messageDecodersMu.Unlock()
+	messageDecoders[typ] = decoder
+}
+
+// messageDecoder 登録済みのデコーダを取得
+func messageDecoder(typ string) MessageDecoder {
+	messageDecodersMu.RLock()
+	defer messageDecodersMu.RUnlock()
+	return messageDecoders[typ]
+}
+
+// IsKnownMessageType 既知(組み込みまたは登録済み)のメッセージタイプであるか判定
+func IsKnownMessageType(typ string) bool {
+	if typ == PayloadTypesChannels || typ == PayloadTypesKeepAlive {
+		return true
+	}
+	return messageDecoder(typ) != nil
+}
+
+// UnmarshalJSON ペイロードタイプに応じてペイロードをデコード
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	type alias Payload
+	var raw struct {
+		alias
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*p = Payload(raw.alias)
+	if len(raw.Payload) == 0 || bytes.Equal(raw.Payload, []byte("null")) {
+		return nil
+	}
+
+	if p.IsChannelValue() {
+		return json.Unmarshal(raw.Payload, &p.Payload)
+	}
+
+	m, err := decodeMessage(p.Type, append([]byte{}, raw.Payload...))
+	if err != nil {
+		return err
+	}
+	p.Message = m
+	return nil
+}
+
+// decodeMessage 登録済みのデコーダでペイロードをデコード(未登録の場合はjson.RawMessage)
+//
+// dataはデコード結果から参照される場合があるため、呼び出し元で再利用しないこと
+func decodeMessage(typ string, data []byte) (interface{}, error) {
+	decoder := messageDecoder(typ)
+	if decoder == nil {
+		return json.RawMessage(data), nil
+	}
+
+	m, err := decoder(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed on decoding %q payload: %s", typ, err)
+	}
+	return m, nil
+}
+
+// MarshalJSON type = channels以外の場合はMessageをペイロードとして出力
+func (p Payload) MarshalJSON() ([]byte, error) {
+	type alias Payload
+	if p.IsChannelValue() || p.Message == nil {
+		return json.Marshal(alias(p))
+	}
+
+	return json.Marshal(struct {
+		alias
+		Payload interface{} `json:"payload"`
+	}{
+		alias:   alias(p),
+		Payload: p.Message,
+	})
+}
+
+// Connection モジュール接続時メッセージのペイロードを取得
+func (p *Payload) Connection() (*ConnectionMessage, bool) {
+	m, ok := p.Message.(*ConnectionMessage)
+	return m, ok && p.IsConnection()
+}
+
+// Location 位置情報メッセージのペイロードを取得
+func (p *Payload) Location() (*LocationMessage, bool) {
+	m, ok := p.Message.(*LocationMessage)
+	return m, ok && p.IsLocation()
+}
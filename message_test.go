@@ -0,0 +1,110 @@
+package sakura
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var connectionTestJSON = `{
+    "module": "XXXXXXXXX",
+    "type": "connection",
+    "datetime": "2016-06-01T12:21:11.628907163Z",
+    "payload": {"is_online": true}
+}`
+
+var locationTestJSON = `{
+    "module": "XXXXXXXXX",
+    "type": "location",
+    "datetime": "2016-06-01T12:21:11.628907163Z",
+    "payload": {"coordinate": {"latitude": 35.6809591, "longitude": 139.7673068, "range_m": 100}}
+}`
+
+var unknownTestJSON = `{
+    "module": "XXXXXXXXX",
+    "type": "unknown-type",
+    "datetime": "2016-06-01T12:21:11.628907163Z",
+    "payload": {"foo": "bar"}
+}`
+
+func TestPayloadUnmarshalJSONConnection(t *testing.T) {
+
+	var payload Payload
+	err := json.Unmarshal([]byte(connectionTestJSON), &payload)
+	assert.NoError(t, err)
+
+	assert.True(t, payload.IsConnection())
+	assert.Len(t, payload.Payload.Channels, 0)
+
+	m, ok := payload.Connection()
+	assert.True(t, ok)
+	assert.True(t, m.IsOnline)
+
+	_, ok = payload.Location()
+	assert.False(t, ok)
+
+	// round trip
+	data, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(data), connectionTestJSON)
+}
+
+func TestPayloadUnmarshalJSONLocation(t *testing.T) {
+
+	var payload Payload
+	err := json.Unmarshal([]byte(locationTestJSON), &payload)
+	assert.NoError(t, err)
+
+	assert.True(t, payload.IsLocation())
+	m, ok := payload.Location()
+	assert.True(t, ok)
+	if assert.NotNil(t, m.Coordinate) {
+		assert.Equal(t, m.Coordinate.Latitude, 35.6809591)
+		assert.Equal(t, m.Coordinate.Longitude, 139.7673068)
+		assert.Equal(t, m.Coordinate.RangeM, float64(100))
+	}
+	assert.NoError(t, payload.Validate())
+}
+
+func TestPayloadUnmarshalJSONUnknownType(t *testing.T) {
+
+	var payload Payload
+	err := json.Unmarshal([]byte(unknownTestJSON), &payload)
+	assert.NoError(t, err)
+
+	raw, ok := payload.Message.(json.RawMessage)
+	assert.True(t, ok)
+	assert.JSONEq(t, string(raw), `{"foo": "bar"}`)
+	assert.Error(t, payload.Validate())
+
+	data, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(data), unknownTestJSON)
+}
+
+type registeredTestMessage struct {
+	Foo string `json:"foo"`
+}
+
+func TestRegisterMessageType(t *testing.T) {
+
+	RegisterMessageType("registered-type", func(data json.RawMessage) (interface{}, error) {
+		m := &registeredTestMessage{}
+		err := json.Unmarshal(data, m)
+		return m, err
+	})
+
+	var payload Payload
+	err := json.Unmarshal([]byte(`{"module": "XXXXXXXXX", "type": "registered-type", "payload": {"foo": "bar"}}`), &payload)
+	assert.NoError(t, err)
+
+	m, ok := payload.Message.(*registeredTestMessage)
+	if assert.True(t, ok) {
+		assert.Equal(t, m.Foo, "bar")
+	}
+	assert.NoError(t, payload.Validate())
+
+	assert.Panics(t, func() {
+		RegisterMessageType(PayloadTypesChannels, func(data json.RawMessage) (interface{}, error) { return nil, nil })
+	})
+}
@@ -0,0 +1,192 @@
+package sakura
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware Handlerをラップしてメッセージ毎の処理を追加する関数
+type Middleware func(next Handler) Handler
+
+// HTTPMiddleware http.Handlerをラップしてリクエスト毎の処理を追加する関数
+type HTTPMiddleware func(next http.Handler) http.Handler
+
+// Chain hをmiddlewaresでラップしたHandlerを返す(先頭のmiddlewareが最も外側となる)
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// PanicError ハンドラ内で発生したpanic
+type PanicError struct {
+	// Value recoverで得られた値
+	Value interface{}
+	// Stack panic発生時のスタックトレース
+	Stack []byte
+}
+
+// Error errorインターフェースの実装
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Panic: %v", e.Value)
+}
+
+func newPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Recover ハンドラ内のpanicをrecoverし、*PanicErrorとして返すミドルウェア
+//
+// panic発生時はfを呼ぶ(nilの場合は標準のlogパッケージへスタックトレースを出力)。
+// goroutine/WorkerPool上で呼ばれるハンドラのpanicはWebhookHandlerが常にrecoverするため、
+// 主に同期的に呼ばれるWebhookHandler.Handlerのpanicを500として応答するために利用する
+func Recover(f func(p Payload, err *PanicError)) Middleware {
+	if f == nil {
+		f = RecoverLog(nil)
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, p Payload) error {
+			perr, err := recoverHandle(ctx, next, p)
+			if perr != nil {
+				f(p, perr)
+			}
+			return err
+		})
+	}
+}
+
+// recoverHandle hを呼び、panicが発生した場合は*PanicErrorを返す(errにも設定される)
+func recoverHandle(ctx context.Context, h Handler, p Payload) (perr *PanicError, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			perr = newPanicError(v)
+			err = perr
+		}
+	}()
+	return nil, h.Handle(ctx, p)
+}
+
+// recoverLogHandler panicをrecoverしてloggerへ出力するHandler
+type recoverLogHandler struct {
+	next   Handler
+	logger Logger
+}
+
+func (h *recoverLogHandler) Handle(ctx context.Context, p Payload) error {
+	perr, err := recoverHandle(ctx, h.next, p)
+	if perr != nil {
+		RecoverLog(h.logger)(p, perr)
+	}
+	return err
+}
+
+// RecoverLog panicをloggerへ出力する関数を返す(Recoverの引数として利用する。loggerがnilの場合は標準のlogパッケージ)
+func RecoverLog(logger Logger) func(p Payload, err *PanicError) {
+	if logger == nil {
+		logger = NewStdLogger(nil, LogLevelDebug)
+	}
+	return func(p Payload, err *PanicError) {
+		logger.Log(LogLevelError, "Recovered from panic", "module", p.Module, "type", p.Type, "error", err, "stack", string(err.Stack))
+	}
+}
+
+// Timing ハンドラの処理時間を計測し、処理完了時にfを呼ぶミドルウェア
+func Timing(f func(p Payload, elapsed time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, p Payload) error {
+			start := time.Now()
+			err := next.Handle(ctx, p)
+			f(p, time.Since(start), err)
+			return err
+		})
+	}
+}
+
+// Logging 処理したメッセージと処理結果をloggerへ出力するミドルウェア(nilの場合は標準のlogパッケージ)
+//
+// 成功時はINFO、ハンドラがエラーを返した場合はWARNレベルとなる
+func Logging(logger Logger) Middleware {
+	if logger == nil {
+		logger = NewStdLogger(nil, LogLevelDebug)
+	}
+	return Timing(func(p Payload, elapsed time.Duration, err error) {
+		if err != nil {
+			logger.Log(LogLevelWarn, "Message handled", "module", p.Module, "type", p.Type, "elapsed", elapsed, "error", err)
+			return
+		}
+		logger.Log(LogLevelInfo, "Message handled", "module", p.Module, "type", p.Type, "elapsed", elapsed)
+	})
+}
+
+// Filter 条件を満たすメッセージのみ後続のハンドラへ渡すミドルウェア(それ以外は何もせず受理する)
+func Filter(m Matcher) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, p Payload) error {
+			if !m(&p) {
+				return nil
+			}
+			return next.Handle(ctx, p)
+		})
+	}
+}
+
+// RecoverHTTP リクエスト処理中のpanicをrecoverし、500を応答するミドルウェア
+//
+// panic発生時はfを呼ぶ(nilの場合は標準のlogパッケージへスタックトレースを出力)
+func RecoverHTTP(f func(r *http.Request, err *PanicError)) HTTPMiddleware {
+	if f == nil {
+		f = RecoverHTTPLog(nil)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if v := recover(); v != nil {
+					f(r, newPanicError(v))
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RecoverHTTPLog panicをloggerへ出力する関数を返す(RecoverHTTPの引数として利用する。loggerがnilの場合は標準のlogパッケージ)
+func RecoverHTTPLog(logger Logger) func(r *http.Request, err *PanicError) {
+	if logger == nil {
+		logger = NewStdLogger(nil, LogLevelDebug)
+	}
+	return func(r *http.Request, err *PanicError) {
+		logger.Log(LogLevelError, "Recovered from panic", "method", r.Method, "path", r.URL.Path, "error", err, "stack", string(err.Stack))
+	}
+}
+
+// LoggingHTTP リクエストと応答したステータスをloggerへ出力するミドルウェア(nilの場合は標準のlogパッケージ)
+func LoggingHTTP(logger Logger) HTTPMiddleware {
+	if logger == nil {
+		logger = NewStdLogger(nil, LogLevelDebug)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			logger.Log(LogLevelInfo, "Request handled", "method", r.Method, "path", r.URL.Path, "status", sw.status,
+				"elapsed", time.Since(start), "remote", r.RemoteAddr)
+		})
+	}
+}
+
+// statusWriter 応答したステータスを記録するhttp.ResponseWriter
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,225 @@
+package sakura
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, p Payload) error {
+				calls = append(calls, name)
+				return next.Handle(ctx, p)
+			})
+		}
+	}
+
+	h := Chain(WebhookHandlerFunc(func(p Payload) { calls = append(calls, "handler") }), mw("first"), mw("second"))
+	err := h.Handle(context.Background(), NewPayload("module"))
+	assert.NoError(t, err)
+	assert.Equal(t, calls, []string{"first", "second", "handler"})
+}
+
+func TestRecover(t *testing.T) {
+
+	var recovered *PanicError
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			panic("boom")
+		}),
+		Middlewares: []Middleware{
+			Recover(func(p Payload, err *PanicError) { recovered = err }),
+		},
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+	assert.Equal(t, recovered.Value, "boom")
+	assert.Equal(t, recovered.Error(), "Panic: boom")
+	assert.Contains(t, string(recovered.Stack), "middleware_test.go")
+}
+
+func TestRecoverAsync(t *testing.T) {
+
+	recovered := make(chan *PanicError, 1)
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {
+			panic(fmt.Errorf("boom"))
+		},
+		Middlewares: []Middleware{
+			Recover(func(p Payload, err *PanicError) { recovered <- err }),
+		},
+	}
+
+	// goroutine上のpanicでもプロセスは終了しない
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+
+	select {
+	case err := <-recovered:
+		assert.EqualError(t, err, "Panic: boom")
+	case <-time.After(time.Second):
+		t.Fatal("panic is not recovered")
+	}
+}
+
+func TestRecoverByDefault(t *testing.T) {
+
+	logger := &testLogger{}
+	panicked := make(chan struct{}, 1)
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {
+			panicked <- struct{}{}
+			panic("boom")
+		},
+		Logger: logger,
+	}
+
+	// Recoverを指定しなくてもgoroutine上のpanicでプロセスは終了しない
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	<-panicked
+
+	logged := func() bool {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		for _, e := range logger.entries {
+			if e.msg == "Recovered from panic" && e.level == LogLevelError {
+				return true
+			}
+		}
+		return false
+	}
+	for deadline := time.Now().Add(time.Second); !logged() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, logged(), "panic is not logged")
+
+	// WorkerPool上で同期的に呼ばれるHandlerのpanicは500となる
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+	h = &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			panic("boom")
+		}),
+		Pool:   pool,
+		Logger: logger,
+	}
+	w = postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+}
+
+func TestRecoverPoolPanicFunc(t *testing.T) {
+
+	panics := make(chan *PanicError, 2)
+	pool := NewWorkerPool(1, 1)
+	pool.PanicFunc = func(p Payload, err *PanicError) {
+		panics <- err
+	}
+	defer pool.Close()
+
+	// Pool上のpanicはWorkerPool.PanicFuncへ渡される
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {
+			panic("async")
+		},
+		Pool: pool,
+	}
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+
+	select {
+	case err := <-panics:
+		assert.Equal(t, err.Value, "async")
+	case <-time.After(time.Second):
+		t.Fatal("PanicFunc is not called")
+	}
+
+	h = &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			panic("sync")
+		}),
+		Pool: pool,
+	}
+	w = postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+	assert.Equal(t, (<-panics).Value, "sync")
+}
+
+func TestRecoverHTTP(t *testing.T) {
+
+	var recovered *PanicError
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			panic("boom")
+		}),
+		HTTPMiddlewares: []HTTPMiddleware{
+			RecoverHTTP(func(r *http.Request, err *PanicError) { recovered = err }),
+		},
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+	assert.Equal(t, recovered.Value, "boom")
+}
+
+func TestLogging(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	logger := NewStdLogger(log.New(buf, "", 0), LogLevelInfo)
+
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			if p.IsConnection() {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		}),
+		Middlewares:     []Middleware{Logging(logger)},
+		HTTPMiddlewares: []HTTPMiddleware{LoggingHTTP(logger)},
+	}
+
+	postWebhook(h, payloadTestJSONInt)
+	postWebhook(h, connectionTestJSON)
+
+	logs := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, logs, 4)
+	assert.True(t, strings.HasPrefix(logs[0], "[INFO] Message handled module=XXXXXXXXX type=channels elapsed="), logs[0])
+	assert.True(t, strings.HasPrefix(logs[1], "[INFO] Request handled method=POST path=/ status=200 "), logs[1])
+	assert.True(t, strings.HasPrefix(logs[2], "[WARN] Message handled module=XXXXXXXXX type=connection elapsed="), logs[2])
+	assert.True(t, strings.HasSuffix(logs[2], " error=failed"), logs[2])
+	assert.True(t, strings.HasPrefix(logs[3], "[INFO] Request handled method=POST path=/ status=500 "), logs[3])
+}
+
+func TestTimingAndFilter(t *testing.T) {
+
+	var timed []string
+	var handled []string
+
+	h := Chain(
+		WebhookHandlerFunc(func(p Payload) { handled = append(handled, p.Module) }),
+		Timing(func(p Payload, elapsed time.Duration, err error) {
+			assert.True(t, elapsed >= 0)
+			timed = append(timed, p.Module)
+		}),
+		Filter(MatchModuleGlob("sensor-*")),
+	)
+
+	for _, module := range []string{"sensor-1", "gps-1", "sensor-2"} {
+		err := h.Handle(context.Background(), NewPayload(module))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, timed, []string{"sensor-1", "gps-1", "sensor-2"})
+	assert.Equal(t, handled, []string{"sensor-1", "sensor-2"})
+}
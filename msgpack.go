@@ -0,0 +1,437 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// msgpackExtTimestamp MessagePackのタイムスタンプ拡張型
+const msgpackExtTimestamp = -1
+
+// MarshalMsgpack ペイロードをMessagePackへエンコード
+//
+// キー名はJSONと同じ、日時はタイムスタンプ拡張型、型コード"b"の値はバイナリとなる。
+// タイムスタンプ拡張型はタイムゾーンを持たないため、デコードした日時は同じ時刻のUTCとなる
+// (タイムゾーンを保持するJSON/CBORとは異なる)
+func (p Payload) MarshalMsgpack() ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := encodePayload(e, &p); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalMsgpack MessagePackからペイロードをデコード
+func (p *Payload) UnmarshalMsgpack(data []byte) error {
+	v, err := decodeMsgpack(data)
+	if err != nil {
+		return err
+	}
+	*p, err = payloadFromTree(v)
+	return err
+}
+
+// MarshalMsgpack チャンネル一覧をMessagePackへエンコード
+func (p InnerPayload) MarshalMsgpack() ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := encodeInnerPayload(e, &p); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalMsgpack MessagePackからチャンネル一覧をデコード
+func (p *InnerPayload) UnmarshalMsgpack(data []byte) error {
+	v, err := decodeMsgpack(data)
+	if err != nil {
+		return err
+	}
+	*p, err = innerPayloadFromTree(v)
+	return err
+}
+
+// MarshalMsgpack チャンネルをMessagePackへエンコード
+func (c Channel) MarshalMsgpack() ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := encodeChannel(e, &c); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalMsgpack MessagePackからチャンネルをデコード
+func (c *Channel) UnmarshalMsgpack(data []byte) error {
+	v, err := decodeMsgpack(data)
+	if err != nil {
+		return err
+	}
+	*c, err = channelFromTree(v)
+	return err
+}
+
+// msgpackEncoder MessagePackエンコーダ
+type msgpackEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *msgpackEncoder) writeUint8(code byte, v uint8) {
+	e.buf.Write([]byte{code, v})
+}
+
+func (e *msgpackEncoder) writeUint16(code byte, v uint16) {
+	var b [3]byte
+	b[0] = code
+	binary.BigEndian.PutUint16(b[1:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *msgpackEncoder) writeUint32(code byte, v uint32) {
+	var b [5]byte
+	b[0] = code
+	binary.BigEndian.PutUint32(b[1:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *msgpackEncoder) writeUint64(code byte, v uint64) {
+	var b [9]byte
+	b[0] = code
+	binary.BigEndian.PutUint64(b[1:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *msgpackEncoder) writeNil() {
+	e.buf.WriteByte(0xc0)
+}
+
+func (e *msgpackEncoder) writeBool(v bool) {
+	if v {
+		e.buf.WriteByte(0xc3)
+	} else {
+		e.buf.WriteByte(0xc2)
+	}
+}
+
+func (e *msgpackEncoder) writeInt(v int64) {
+	switch {
+	case v >= 0:
+		e.writeUint(uint64(v))
+	case v >= -32:
+		e.buf.WriteByte(byte(v))
+	case v >= math.MinInt8:
+		e.writeUint8(0xd0, uint8(v))
+	case v >= math.MinInt16:
+		e.writeUint16(0xd1, uint16(v))
+	case v >= math.MinInt32:
+		e.writeUint32(0xd2, uint32(v))
+	default:
+		e.writeUint64(0xd3, uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) writeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		e.buf.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		e.writeUint8(0xcc, uint8(v))
+	case v <= math.MaxUint16:
+		e.writeUint16(0xcd, uint16(v))
+	case v <= math.MaxUint32:
+		e.writeUint32(0xce, uint32(v))
+	default:
+		e.writeUint64(0xcf, v)
+	}
+}
+
+func (e *msgpackEncoder) writeFloat32(v float32) {
+	e.writeUint32(0xca, math.Float32bits(v))
+}
+
+func (e *msgpackEncoder) writeFloat64(v float64) {
+	e.writeUint64(0xcb, math.Float64bits(v))
+}
+
+func (e *msgpackEncoder) writeString(v string) {
+	n := len(v)
+	switch {
+	case n <= 31:
+		e.buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.writeUint8(0xd9, uint8(n))
+	case n <= math.MaxUint16:
+		e.writeUint16(0xda, uint16(n))
+	default:
+		e.writeUint32(0xdb, uint32(n))
+	}
+	e.buf.WriteString(v)
+}
+
+func (e *msgpackEncoder) writeBytes(v []byte) {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		e.writeUint8(0xc4, uint8(n))
+	case n <= math.MaxUint16:
+		e.writeUint16(0xc5, uint16(n))
+	default:
+		e.writeUint32(0xc6, uint32(n))
+	}
+	e.buf.Write(v)
+}
+
+func (e *msgpackEncoder) writeTime(v time.Time) {
+	sec, nsec := v.Unix(), int64(v.Nanosecond())
+	switch {
+	case sec >= 0 && sec>>34 == 0 && nsec == 0:
+		e.buf.Write([]byte{0xd6, 0xff})
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(sec))
+		e.buf.Write(b[:])
+	case sec >= 0 && sec>>34 == 0:
+		e.buf.Write([]byte{0xd7, 0xff})
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(nsec)<<34|uint64(sec))
+		e.buf.Write(b[:])
+	default:
+		e.buf.Write([]byte{0xc7, 12, 0xff})
+		var b [12]byte
+		binary.BigEndian.PutUint32(b[:4], uint32(nsec))
+		binary.BigEndian.PutUint64(b[4:], uint64(sec))
+		e.buf.Write(b[:])
+	}
+}
+
+func (e *msgpackEncoder) writeArrayHeader(n int) {
+	switch {
+	case n <= 15:
+		e.buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		e.writeUint16(0xdc, uint16(n))
+	default:
+		e.writeUint32(0xdd, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) writeMapHeader(n int) {
+	switch {
+	case n <= 15:
+		e.buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		e.writeUint16(0xde, uint16(n))
+	default:
+		e.writeUint32(0xdf, uint32(n))
+	}
+}
+
+// msgpackDecoder MessagePackデコーダ
+//
+// デコード結果はuint64/int64/float32/float64/[]byte/string/bool/nil/time.Time、
+// []interface{}、map[string]interface{}のいずれかとなる
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func decodeMsgpack(data []byte) (interface{}, error) {
+	d := &msgpackDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MessagePack: %s", err)
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("Invalid MessagePack: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readUint sizeバイトのビッグエンディアン符号なし整数を読み込む
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.read(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxBinaryDepth {
+		return nil, fmt.Errorf("too deep nesting")
+	}
+
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	code := b[0]
+
+	switch {
+	case code <= 0x7f:
+		return uint64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.decodeMap(uint64(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return d.decodeArray(uint64(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return d.decodeString(uint64(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readUint(1 << (code - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xca:
+		n, err := d.readUint(4)
+		return math.Float32frombits(uint32(n)), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.readUint(1 << (code - 0xcc))
+	case 0xd0:
+		n, err := d.readUint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.readUint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.readUint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.readUint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (code - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	}
+	return nil, fmt.Errorf("unsupported format 0x%02x", code)
+}
+
+func (d *msgpackDecoder) decodeString(n uint64) (interface{}, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) decodeArray(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	ret := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n uint64, depth int) (interface{}, error) {
+	ret := map[string]interface{}{}
+	for i := uint64(0); i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string")
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = v
+	}
+	return ret, nil
+}
+
+// decodeExt 拡張型を読み込む(タイムスタンプのみ対応、UTCとなる)
+func (d *msgpackDecoder) decodeExt(n uint64) (interface{}, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	typ := int8(b[0])
+	data, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	if typ != msgpackExtTimestamp {
+		return nil, fmt.Errorf("unsupported extension type %d", typ)
+	}
+
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data[:4])
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	}
+	return nil, fmt.Errorf("invalid timestamp length %d", n)
+}
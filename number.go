@@ -0,0 +1,147 @@
+package sakura
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// intValue Valueをbitsビットの符号付き整数として取得(範囲外の場合はエラー)
+func (c *Channel) intValue(bits int) (int64, error) {
+	if v, ok := c.numberValue(); ok {
+		if i, err := strconv.ParseInt(v, 10, bits); err == nil {
+			return i, nil
+		}
+	}
+
+	n, err := toBigInt(c.value())
+	if err != nil {
+		return 0, err
+	}
+
+	min := new(big.Int).Lsh(big.NewInt(-1), uint(bits-1))
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits-1)), big.NewInt(1))
+	if n.Cmp(min) < 0 || n.Cmp(max) > 0 {
+		return 0, fmt.Errorf("Value %s overflows int%d", n, bits)
+	}
+	return n.Int64(), nil
+}
+
+// uintValue Valueをbitsビットの符号なし整数として取得(範囲外の場合はエラー)
+func (c *Channel) uintValue(bits int) (uint64, error) {
+	if v, ok := c.numberValue(); ok {
+		if i, err := strconv.ParseUint(v, 10, bits); err == nil {
+			return i, nil
+		}
+	}
+
+	n, err := toBigInt(c.value())
+	if err != nil {
+		return 0, err
+	}
+
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1))
+	if n.Sign() < 0 || n.Cmp(max) > 0 {
+		return 0, fmt.Errorf("Value %s overflows uint%d", n, bits)
+	}
+	return n.Uint64(), nil
+}
+
+// toBigInt 数値を誤差なく整数へ変換(整数でない場合はエラー)
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("Value is nil")
+	case json.Number:
+		r, ok := new(big.Rat).SetString(string(v))
+		if !ok {
+			return nil, fmt.Errorf("Value is not a number")
+		}
+		if !r.IsInt() {
+			return nil, fmt.Errorf("Value %s is not an integer", v)
+		}
+		return r.Num(), nil
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("Value %v is not an integer", v)
+		}
+		n, _ := big.NewFloat(v).Int(nil)
+		return n, nil
+	case float32:
+		return toBigInt(float64(v))
+	case int:
+		return big.NewInt(int64(v)), nil
+	case int8:
+		return big.NewInt(int64(v)), nil
+	case int16:
+		return big.NewInt(int64(v)), nil
+	case int32:
+		return big.NewInt(int64(v)), nil
+	case int64:
+		return big.NewInt(v), nil
+	case uint:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint16:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint32:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint64:
+		return new(big.Int).SetUint64(v), nil
+	}
+	return nil, fmt.Errorf("Value is not a number")
+}
+
+// floatValue Valueをbitsビットの浮動小数点数として取得(範囲外の場合はエラー)
+func (c *Channel) floatValue(bits int) (float64, error) {
+	if v, ok := c.numberValue(); ok {
+		f, err := strconv.ParseFloat(v, bits)
+		if err != nil {
+			if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+				return 0, fmt.Errorf("Value %s overflows float%d", v, bits)
+			}
+			return 0, fmt.Errorf("Value is not a number")
+		}
+		return f, nil
+	}
+
+	var f float64
+	switch v := c.value().(type) {
+	case nil:
+		return 0, fmt.Errorf("Value is nil")
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	case int8:
+		f = float64(v)
+	case int16:
+		f = float64(v)
+	case int32:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case uint:
+		f = float64(v)
+	case uint8:
+		f = float64(v)
+	case uint16:
+		f = float64(v)
+	case uint32:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	default:
+		return 0, fmt.Errorf("Value is not a number")
+	}
+
+	if bits == 32 && !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+		return 0, fmt.Errorf("Value %v overflows float32", f)
+	}
+	return f, nil
+}
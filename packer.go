@@ -0,0 +1,284 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// PackedSize 16進文字列チャンネル(型コード"b")1つに格納できるバイト数
+const PackedSize = 8
+
+// Packer 複数の値を16進文字列チャンネル用の8バイトへパックする
+//
+// 書き込みが8バイトを超えた場合などのエラーは蓄積され、Bytes()で返される
+type Packer struct {
+	order binary.ByteOrder
+	buf   [PackedSize]byte
+	pos   int
+	err   error
+}
+
+// NewPacker 指定のバイトオーダーでパックする*Packerを作成
+func NewPacker(order binary.ByteOrder) *Packer {
+	return &Packer{order: order}
+}
+
+// next 次のsizeバイト分の領域を確保
+func (p *Packer) next(size int) []byte {
+	if p.err != nil {
+		return nil
+	}
+	if p.pos+size > PackedSize {
+		p.err = fmt.Errorf("Packer: %d bytes exceeds %d bytes", p.pos+size, PackedSize)
+		return nil
+	}
+	b := p.buf[p.pos : p.pos+size]
+	p.pos += size
+	return b
+}
+
+// Int8 int8型の値を追加
+func (p *Packer) Int8(v int8) *Packer {
+	return p.Uint8(uint8(v))
+}
+
+// Uint8 uint8型の値を追加
+func (p *Packer) Uint8(v uint8) *Packer {
+	if b := p.next(1); b != nil {
+		b[0] = v
+	}
+	return p
+}
+
+// Int16 int16型の値を追加
+func (p *Packer) Int16(v int16) *Packer {
+	return p.Uint16(uint16(v))
+}
+
+// Uint16 uint16型の値を追加
+func (p *Packer) Uint16(v uint16) *Packer {
+	if b := p.next(2); b != nil {
+		p.order.PutUint16(b, v)
+	}
+	return p
+}
+
+// Int32 int32型の値を追加
+func (p *Packer) Int32(v int32) *Packer {
+	return p.Uint32(uint32(v))
+}
+
+// Uint32 uint32型の値を追加
+func (p *Packer) Uint32(v uint32) *Packer {
+	if b := p.next(4); b != nil {
+		p.order.PutUint32(b, v)
+	}
+	return p
+}
+
+// Int64 int64型の値を追加
+func (p *Packer) Int64(v int64) *Packer {
+	return p.Uint64(uint64(v))
+}
+
+// Uint64 uint64型の値を追加
+func (p *Packer) Uint64(v uint64) *Packer {
+	if b := p.next(8); b != nil {
+		p.order.PutUint64(b, v)
+	}
+	return p
+}
+
+// Float16 float32型の値を半精度浮動小数点数(IEEE 754 binary16)として追加
+func (p *Packer) Float16(v float32) *Packer {
+	h := float32ToFloat16(v)
+	if p.err == nil && h&0x7fff == 0x7c00 && !math.IsInf(float64(v), 0) {
+		p.err = fmt.Errorf("Packer: %v overflows float16", v)
+		return p
+	}
+	return p.Uint16(h)
+}
+
+// Float32 float32型の値を追加
+func (p *Packer) Float32(v float32) *Packer {
+	return p.Uint32(math.Float32bits(v))
+}
+
+// Float64 float64型の値を追加
+func (p *Packer) Float64(v float64) *Packer {
+	return p.Uint64(math.Float64bits(v))
+}
+
+// Len パック済みのバイト数
+func (p *Packer) Len() int {
+	return p.pos
+}
+
+// Bytes パック結果を取得(未使用の領域はゼロ埋め)
+func (p *Packer) Bytes() ([PackedSize]byte, error) {
+	return p.buf, p.err
+}
+
+// Unpacker 16進文字列チャンネル用の8バイトから値を順に取り出す
+//
+// 8バイトを超えて読み出した場合などのエラーは蓄積され、Err()で返される
+type Unpacker struct {
+	order binary.ByteOrder
+	buf   [PackedSize]byte
+	pos   int
+	err   error
+}
+
+// NewUnpacker 指定のバイトオーダーでアンパックする*Unpackerを作成
+func NewUnpacker(b [PackedSize]byte, order binary.ByteOrder) *Unpacker {
+	return &Unpacker{order: order, buf: b}
+}
+
+// next 次のsizeバイト分の領域を取得
+func (u *Unpacker) next(size int) []byte {
+	if u.err != nil {
+		return nil
+	}
+	if u.pos+size > PackedSize {
+		u.err = fmt.Errorf("Unpacker: %d bytes exceeds %d bytes", u.pos+size, PackedSize)
+		return nil
+	}
+	b := u.buf[u.pos : u.pos+size]
+	u.pos += size
+	return b
+}
+
+// Int8 int8型の値を取得
+func (u *Unpacker) Int8() int8 {
+	return int8(u.Uint8())
+}
+
+// Uint8 uint8型の値を取得
+func (u *Unpacker) Uint8() uint8 {
+	if b := u.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// Int16 int16型の値を取得
+func (u *Unpacker) Int16() int16 {
+	return int16(u.Uint16())
+}
+
+// Uint16 uint16型の値を取得
+func (u *Unpacker) Uint16() uint16 {
+	if b := u.next(2); b != nil {
+		return u.order.Uint16(b)
+	}
+	return 0
+}
+
+// Int32 int32型の値を取得
+func (u *Unpacker) Int32() int32 {
+	return int32(u.Uint32())
+}
+
+// Uint32 uint32型の値を取得
+func (u *Unpacker) Uint32() uint32 {
+	if b := u.next(4); b != nil {
+		return u.order.Uint32(b)
+	}
+	return 0
+}
+
+// Int64 int64型の値を取得
+func (u *Unpacker) Int64() int64 {
+	return int64(u.Uint64())
+}
+
+// Uint64 uint64型の値を取得
+func (u *Unpacker) Uint64() uint64 {
+	if b := u.next(8); b != nil {
+		return u.order.Uint64(b)
+	}
+	return 0
+}
+
+// Float16 半精度浮動小数点数(IEEE 754 binary16)をfloat32型として取得
+func (u *Unpacker) Float16() float32 {
+	return float16ToFloat32(u.Uint16())
+}
+
+// Float32 float32型の値を取得
+func (u *Unpacker) Float32() float32 {
+	return math.Float32frombits(u.Uint32())
+}
+
+// Float64 float64型の値を取得
+func (u *Unpacker) Float64() float64 {
+	return math.Float64frombits(u.Uint64())
+}
+
+// Err アンパック中に発生したエラーを取得
+func (u *Unpacker) Err() error {
+	return u.err
+}
+
+// float32ToFloat16 float32を半精度浮動小数点数へ変換(最近接偶数丸め)
+func float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	frac := bits & 0x7fffff
+
+	if exp == 0xff {
+		if frac != 0 {
+			return sign | 0x7e00 // NaN
+		}
+		return sign | 0x7c00 // Inf
+	}
+
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return sign | 0x7c00
+	}
+
+	if e <= 0 {
+		// 非正規化数またはゼロ
+		if e < -10 {
+			return sign
+		}
+		m := frac | 0x800000
+		shift := uint(14 - e)
+		h := m >> shift
+		rem := m & (1<<shift - 1)
+		half := uint32(1) << (shift - 1)
+		if rem > half || (rem == half && h&1 == 1) {
+			h++
+		}
+		return sign | uint16(h)
+	}
+
+	h := sign | uint16(e)<<10 | uint16(frac>>13)
+	rem := frac & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		h++
+	}
+	return h
+}
+
+// float16ToFloat32 半精度浮動小数点数をfloat32へ変換
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		v := float32(math.Ldexp(float64(frac), -24))
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp-15+127)<<23 | frac<<13)
+}
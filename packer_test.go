@@ -0,0 +1,105 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestPackerRoundTrip(t *testing.T) {
+
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		b, err := NewPacker(order).
+			Int16(-12345).
+			Uint8(200).
+			Float16(1.5).
+			Int8(-1).
+			Uint16(65535).
+			Bytes()
+		assert.NoError(t, err)
+
+		u := NewUnpacker(b, order)
+		assert.Equal(t, u.Int16(), int16(-12345))
+		assert.Equal(t, u.Uint8(), uint8(200))
+		assert.Equal(t, u.Float16(), float32(1.5))
+		assert.Equal(t, u.Int8(), int8(-1))
+		assert.Equal(t, u.Uint16(), uint16(65535))
+		assert.NoError(t, u.Err())
+	}
+}
+
+func TestPackerByteOrder(t *testing.T) {
+
+	b, err := NewPacker(binary.BigEndian).Uint32(0x01020304).Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, b, [8]byte{0x01, 0x02, 0x03, 0x04})
+
+	b, err = NewPacker(binary.LittleEndian).Uint32(0x01020304).Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, b, [8]byte{0x04, 0x03, 0x02, 0x01})
+}
+
+func TestPackerOverflow(t *testing.T) {
+
+	_, err := NewPacker(binary.BigEndian).Uint32(1).Uint32(2).Uint8(3).Bytes()
+	assert.Error(t, err)
+
+	_, err = NewPacker(binary.BigEndian).Float16(65520).Bytes()
+	assert.Error(t, err)
+
+	u := NewUnpacker([8]byte{}, binary.BigEndian)
+	u.Uint64()
+	assert.NoError(t, u.Err())
+	assert.Equal(t, u.Uint8(), uint8(0))
+	assert.Error(t, u.Err())
+}
+
+func TestFloat16Conversion(t *testing.T) {
+
+	expects := []struct {
+		f float32
+		h uint16
+	}{
+		{f: 0, h: 0x0000},
+		{f: 1, h: 0x3c00},
+		{f: -2, h: 0xc000},
+		{f: 65504, h: 0x7bff},
+		{f: float32(math.Ldexp(1, -14)), h: 0x0400},
+		{f: float32(math.Ldexp(1, -24)), h: 0x0001},
+		{f: float32(math.Inf(1)), h: 0x7c00},
+		{f: float32(math.Inf(-1)), h: 0xfc00},
+	}
+	for _, expect := range expects {
+		assert.Equal(t, float32ToFloat16(expect.f), expect.h)
+		assert.Equal(t, float16ToFloat32(expect.h), expect.f)
+	}
+
+	// rounding
+	assert.Equal(t, float32ToFloat16(0.1), uint16(0x2e66))
+	assert.Equal(t, float32ToFloat16(float32(math.Ldexp(1, -26))), uint16(0x0000))
+	assert.True(t, math.IsNaN(float64(float16ToFloat32(float32ToFloat16(float32(math.NaN()))))))
+}
+
+func TestChannelBytes(t *testing.T) {
+
+	b, err := NewPacker(binary.BigEndian).Int16(-2).Uint8(1).Float16(0.5).Bytes()
+	assert.NoError(t, err)
+
+	payload := NewPayload("xxxxxxxx10xx")
+	payload.AddValueByBytes(0, b)
+	assert.NoError(t, payload.Validate())
+
+	c := payload.Payload.Channels[0]
+	assert.Equal(t, c.ChannelType(), ChannelTypeHexString)
+	assert.Equal(t, c.Value, "fffe013800000000")
+
+	got, err := c.GetBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, got, b)
+
+	u := NewUnpacker(got, binary.BigEndian)
+	assert.Equal(t, u.Int16(), int16(-2))
+	assert.Equal(t, u.Uint8(), uint8(1))
+	assert.Equal(t, u.Float16(), float32(0.5))
+}
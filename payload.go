@@ -1,7 +1,11 @@
 package sakura
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,6 +16,28 @@ var (
 	PayloadTypesChannels = "channels"
 	// PayloadTypesConnection モジュール接続時メッセージを表すペイロードタイプ
 	PayloadTypesConnection = "connection"
+	// PayloadTypesLocation 位置情報メッセージを表すペイロードタイプ
+	PayloadTypesLocation = "location"
+)
+
+// ChannelType チャンネル値の型コード(Channel.Typeの値)
+type ChannelType string
+
+const (
+	// ChannelTypeInt int32型を表す型コード
+	ChannelTypeInt ChannelType = "i"
+	// ChannelTypeUint uint32型を表す型コード
+	ChannelTypeUint ChannelType = "I"
+	// ChannelTypeInt64 int64型を表す型コード
+	ChannelTypeInt64 ChannelType = "l"
+	// ChannelTypeUint64 uint64型を表す型コード
+	ChannelTypeUint64 ChannelType = "L"
+	// ChannelTypeFloat float(float32)型を表す型コード
+	ChannelTypeFloat ChannelType = "f"
+	// ChannelTypeDouble double(float64)型を表す型コード
+	ChannelTypeDouble ChannelType = "d"
+	// ChannelTypeHexString 16進文字列(16文字で1セット)を表す型コード
+	ChannelTypeHexString ChannelType = "b"
 )
 
 // Payload Webhook/WebSocketでやりとりされるデータのペイロード
@@ -20,6 +46,13 @@ type Payload struct {
 	Module   string       `json:"module"`
 	Payload  InnerPayload `json:"payload"`
 	Type     string       `json:"type"`
+
+	// Message type = channels以外のメッセージのペイロード
+	//
+	// 既知のタイプは*ConnectionMessage/*LocationMessage、
+	// RegisterMessageTypeで登録したタイプはそのデコード結果、
+	// 未知のタイプはjson.RawMessageとなる
+	Message interface{} `json:"-"`
 }
 
 // NewPayload 新規ペイロード作成
@@ -48,6 +81,11 @@ func (p *Payload) IsConnection() bool {
 	return p.Type == PayloadTypesConnection
 }
 
+// IsLocation ペイロードタイプが位置情報メッセージであるか判定
+func (p *Payload) IsLocation() bool {
+	return p.Type == PayloadTypesLocation
+}
+
 func (p *Payload) addChannel(c Channel) {
 	p.Payload.Channels = append(p.Payload.Channels, c)
 }
@@ -59,6 +97,13 @@ func (p *Payload) AddValueByHexString(channel int64, value string) {
 	p.addChannel(c)
 }
 
+// AddValueByBytes 8バイトの配列を16進文字列として指定チャンネルに追加
+func (p *Payload) AddValueByBytes(channel int64, value [8]byte) {
+	c := newChannel(channel)
+	c.SetBytes(value)
+	p.addChannel(c)
+}
+
 // AddValueByInt int32型の値を指定チャンネルに追加
 func (p *Payload) AddValueByInt(channel int64, value int32) {
 	c := newChannel(channel)
@@ -101,6 +146,31 @@ func (p *Payload) AddValueByDouble(channel int64, value float64) {
 	p.addChannel(c)
 }
 
+// Split チャンネル数がmax以下となるようペイロードを分割
+//
+// type = channels以外、またはmaxが0以下の場合は分割しない
+func (p *Payload) Split(max int) []Payload {
+	channels := p.Payload.Channels
+	if !p.IsChannelValue() || max <= 0 || len(channels) <= max {
+		return []Payload{*p}
+	}
+
+	ret := make([]Payload, 0, (len(channels)+max-1)/max)
+	for len(channels) > 0 {
+		n := max
+		if len(channels) < n {
+			n = len(channels)
+		}
+		chunk := *p
+		chunk.Payload = InnerPayload{
+			Channels: append([]Channel{}, channels[:n]...),
+		}
+		ret = append(ret, chunk)
+		channels = channels[n:]
+	}
+	return ret
+}
+
 // ClearValues ペイロードに含まれる全ての値をクリア
 func (p *Payload) ClearValues() {
 	p.Payload.Channels = []Channel{}
@@ -113,12 +183,86 @@ type InnerPayload struct {
 	Channels []Channel `json:"channels"`
 }
 
+// Sample チャンネル値を時系列データとして展開したもの
+type Sample struct {
+	Module  string
+	Channel int64
+	Type    ChannelType
+	Time    time.Time
+	// Value Typed()で取得した値(int32/uint32/int64/uint64/float32/float64/[8]byte)
+	Value interface{}
+}
+
+// Samples ペイロードを時系列データへ展開(時刻の指定がない場合は現在時刻を利用)
+func (p *Payload) Samples() ([]Sample, error) {
+	return p.SamplesAt(time.Now())
+}
+
+// SamplesAt ペイロードを時系列データへ展開
+//
+// 各サンプルの時刻はChannel.Datetime、Payload.Datetime、receivedの順で決定する
+//
+// 値を変換できないチャンネルは読み飛ばし、変換できたサンプルとともにエラーを返す
+func (p *Payload) SamplesAt(received time.Time) ([]Sample, error) {
+	samples := make([]Sample, 0, len(p.Payload.Channels))
+	if !p.IsChannelValue() {
+		return samples, nil
+	}
+
+	base := received
+	if p.Datetime != nil {
+		base = *p.Datetime
+	}
+
+	errors := []string{}
+	for i := range p.Payload.Channels {
+		c := &p.Payload.Channels[i]
+		v, err := c.Typed()
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Channel %d: %s", c.Channel, err))
+			continue
+		}
+
+		t := base
+		if c.Datetime != nil {
+			t = *c.Datetime
+		}
+
+		samples = append(samples, Sample{
+			Module:  p.Module,
+			Channel: c.Channel,
+			Type:    c.ChannelType(),
+			Time:    t,
+			Value:   v.Value,
+		})
+	}
+
+	if len(errors) != 0 {
+		return samples, fmt.Errorf("Invalid channels:\n%s", strings.Join(errors, "\n"))
+	}
+	return samples, nil
+}
+
 // Channel Payload内部の実データ格納用の構造体
+//
+// WebhookHandler.LazyValueを指定してデコードした場合、Valueは未設定(nil)となる。
+// 値はGet*()またはGetValue()で取得する
 type Channel struct {
 	Channel  int64       `json:"channel"`
 	Type     string      `json:"type"`
 	Value    interface{} `json:"value"`
 	Datetime *time.Time  `json:"datetime,omitempty"`
+
+	// raw Valueへ格納していない値
+	raw rawValue
+}
+
+// rawValue 型コード付きの未変換のチャンネル値
+//
+// textはデコード結果の文字列を部分参照するため、値毎のアロケーションは発生しない
+type rawValue struct {
+	text string
+	kind byte // 0: なし, 'n': 数値(json.Number), 's': 文字列
 }
 
 func newChannel(channel int64) Channel {
@@ -127,13 +271,99 @@ func newChannel(channel int64) Channel {
 	}
 }
 
+// UnmarshalJSON 数値をjson.Numberとして保持し、64bit値を欠損なく復元する
+func (c *Channel) UnmarshalJSON(data []byte) error {
+	type alias Channel
+	var raw struct {
+		alias
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = Channel(raw.alias)
+	c.Value = nil
+	if len(raw.Value) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw.Value))
+	dec.UseNumber()
+	return dec.Decode(&c.Value)
+}
+
+// MarshalJSON Valueが未設定の場合も値を含めて出力する
+func (c Channel) MarshalJSON() ([]byte, error) {
+	type alias Channel
+	a := alias(c)
+	a.Value = c.value()
+	return json.Marshal(a)
+}
+
+// GetValue 値を取得(Valueが未設定の場合は生成してValueへ設定する)
+func (c *Channel) GetValue() interface{} {
+	c.Value = c.value()
+	c.raw = rawValue{}
+	return c.Value
+}
+
+// value 値を取得(Valueが設定されている場合はそちらを優先する)
+func (c *Channel) value() interface{} {
+	if c.Value != nil {
+		return c.Value
+	}
+	switch c.raw.kind {
+	case 'n':
+		return json.Number(c.raw.text)
+	case 's':
+		return c.raw.text
+	}
+	return nil
+}
+
+// numberValue 値が数値(json.Number)の場合はその文字列表現を取得
+func (c *Channel) numberValue() (string, bool) {
+	if c.Value == nil {
+		return c.raw.text, c.raw.kind == 'n'
+	}
+	v, ok := c.Value.(json.Number)
+	return string(v), ok
+}
+
+// stringValue 値が文字列の場合はその値を取得
+func (c *Channel) stringValue() (string, bool) {
+	if c.Value == nil {
+		return c.raw.text, c.raw.kind == 's'
+	}
+	v, ok := c.Value.(string)
+	return v, ok
+}
+
+// ChannelType 型コードを取得
+func (c *Channel) ChannelType() ChannelType {
+	return ChannelType(c.Type)
+}
+
+// checkType チャンネルの型コードが期待するものであるか確認
+func (c *Channel) checkType(t ChannelType) error {
+	if c.ChannelType() != t {
+		return fmt.Errorf("Type %q is not %q", c.Type, t)
+	}
+	return nil
+}
+
 // GetHexString 16進文字列(16文字で1セット)を取得
 func (c *Channel) GetHexString() (string, error) {
-	if c.Value == nil {
+	if err := c.checkType(ChannelTypeHexString); err != nil {
+		return "", err
+	}
+
+	if c.Value == nil && c.raw.kind == 0 {
 		return "", fmt.Errorf("Value is nil")
 	}
 
-	if v, ok := c.Value.(string); ok {
+	if v, ok := c.stringValue(); ok {
 		return v, nil
 	}
 
@@ -143,119 +373,169 @@ func (c *Channel) GetHexString() (string, error) {
 // SetHexString 16進文字列(16文字で1セット)を設定
 func (c *Channel) SetHexString(v string) {
 	c.Value = v
-	c.Type = "b"
+	c.Type = string(ChannelTypeHexString)
 }
 
 // GetInt int32型データを取得
 func (c *Channel) GetInt() (int32, error) {
-	if c.Value == nil {
-		return int32(0), fmt.Errorf("Value is nil")
+	if err := c.checkType(ChannelTypeInt); err != nil {
+		return int32(0), err
 	}
 
-	if v, ok := c.Value.(float64); ok {
-		return int32(v), nil
+	v, err := c.intValue(32)
+	if err != nil {
+		return int32(0), err
 	}
-
-	return int32(0), fmt.Errorf("Value is not a number")
+	return int32(v), nil
 }
 
 // SetInt int32型データを設定
 func (c *Channel) SetInt(v int32) {
 	c.Value = v
-	c.Type = "i"
+	c.Type = string(ChannelTypeInt)
 }
 
 // GetUint uint32型データを取得
 func (c *Channel) GetUint() (uint32, error) {
-	if c.Value == nil {
-		return uint32(0), fmt.Errorf("Value is nil")
+	if err := c.checkType(ChannelTypeUint); err != nil {
+		return uint32(0), err
 	}
 
-	if v, ok := c.Value.(float64); ok {
-		return uint32(v), nil
+	v, err := c.uintValue(32)
+	if err != nil {
+		return uint32(0), err
 	}
-
-	return uint32(0), fmt.Errorf("Value is not a number")
+	return uint32(v), nil
 }
 
 // SetUint uint32型データを設定
 func (c *Channel) SetUint(v uint32) {
 	c.Value = v
-	c.Type = "I"
+	c.Type = string(ChannelTypeUint)
 }
 
 // GetInt64 int64型データを取得
 func (c *Channel) GetInt64() (int64, error) {
-	if c.Value == nil {
-		return int64(0), fmt.Errorf("Value is nil")
+	if err := c.checkType(ChannelTypeInt64); err != nil {
+		return int64(0), err
 	}
 
-	if v, ok := c.Value.(float64); ok {
-		return int64(v), nil
-	}
-
-	return int64(0), fmt.Errorf("Value is not a number")
+	return c.intValue(64)
 }
 
 // SetInt64 int64型データを設定
 func (c *Channel) SetInt64(v int64) {
 	c.Value = v
-	c.Type = "l"
+	c.Type = string(ChannelTypeInt64)
 }
 
 // GetUint64 uint64型データを取得
 func (c *Channel) GetUint64() (uint64, error) {
-	if c.Value == nil {
-		return uint64(0), fmt.Errorf("Value is nil")
+	if err := c.checkType(ChannelTypeUint64); err != nil {
+		return uint64(0), err
 	}
 
-	if v, ok := c.Value.(float64); ok {
-		return uint64(v), nil
-	}
-
-	return uint64(0), fmt.Errorf("Value is not a number")
+	return c.uintValue(64)
 }
 
 // SetUint64 uint64型データを設定
 func (c *Channel) SetUint64(v uint64) {
 	c.Value = v
-	c.Type = "L"
+	c.Type = string(ChannelTypeUint64)
 }
 
 // GetFloat float(float32)型データを取得
 func (c *Channel) GetFloat() (float32, error) {
-	if c.Value == nil {
-		return float32(0), fmt.Errorf("Value is nil")
+	if err := c.checkType(ChannelTypeFloat); err != nil {
+		return float32(0), err
 	}
 
-	if v, ok := c.Value.(float64); ok {
-		return float32(v), nil
+	v, err := c.floatValue(32)
+	if err != nil {
+		return float32(0), err
 	}
-
-	return float32(0), fmt.Errorf("Value is not a number")
+	return float32(v), nil
 }
 
 // SetFloat float(float32)型データを設定
 func (c *Channel) SetFloat(v float32) {
 	c.Value = v
-	c.Type = "f"
+	c.Type = string(ChannelTypeFloat)
 }
 
 // GetDouble double(float64)型データを取得
 func (c *Channel) GetDouble() (float64, error) {
-	if c.Value == nil {
-		return float64(0), fmt.Errorf("Value is nil")
+	if err := c.checkType(ChannelTypeDouble); err != nil {
+		return float64(0), err
 	}
 
-	if v, ok := c.Value.(float64); ok {
-		return float64(v), nil
-	}
-
-	return float64(0), fmt.Errorf("Value is not a number")
+	return c.floatValue(64)
 }
 
 // SetDouble double(float64)型データを設定
 func (c *Channel) SetDouble(v float64) {
 	c.Value = v
-	c.Type = "d"
+	c.Type = string(ChannelTypeDouble)
+}
+
+// ChannelValue 型コードに応じたGoの型へ変換済みのチャンネル値
+//
+// Valueはint32/uint32/int64/uint64/float32/float64/[8]byteのいずれか
+type ChannelValue struct {
+	Type  ChannelType
+	Value interface{}
+}
+
+// Typed 型コード(i/I/l/L/f/d/b)に応じたGoの型で値を取得
+func (c *Channel) Typed() (ChannelValue, error) {
+	var (
+		v   interface{}
+		err error
+	)
+
+	switch c.ChannelType() {
+	case ChannelTypeInt:
+		v, err = c.GetInt()
+	case ChannelTypeUint:
+		v, err = c.GetUint()
+	case ChannelTypeInt64:
+		v, err = c.GetInt64()
+	case ChannelTypeUint64:
+		v, err = c.GetUint64()
+	case ChannelTypeFloat:
+		v, err = c.GetFloat()
+	case ChannelTypeDouble:
+		v, err = c.GetDouble()
+	case ChannelTypeHexString:
+		v, err = c.GetBytes()
+	default:
+		return ChannelValue{}, fmt.Errorf("Unknown channel type %q", c.Type)
+	}
+	if err != nil {
+		return ChannelValue{}, err
+	}
+
+	return ChannelValue{Type: c.ChannelType(), Value: v}, nil
+}
+
+// GetBytes 16進文字列(16文字で1セット)を8バイトの配列として取得
+func (c *Channel) GetBytes() ([8]byte, error) {
+	var ret [8]byte
+
+	s, err := c.GetHexString()
+	if err != nil {
+		return ret, err
+	}
+	if len(s) != hex.EncodedLen(len(ret)) {
+		return ret, fmt.Errorf("HexString %q must be %d characters", s, hex.EncodedLen(len(ret)))
+	}
+	if _, err := hex.Decode(ret[:], []byte(s)); err != nil {
+		return ret, fmt.Errorf("HexString %q is invalid: %s", s, err)
+	}
+	return ret, nil
+}
+
+// SetBytes 8バイトの配列を16進文字列(16文字で1セット)として設定
+func (c *Channel) SetBytes(v [8]byte) {
+	c.SetHexString(hex.EncodeToString(v[:]))
 }
@@ -220,3 +220,304 @@ func TestPayloadHandleChannels(t *testing.T) {
 	payload.ClearValues()
 	assert.Len(t, payload.Payload.Channels, 0)
 }
+
+func TestPayloadUnmarshalJSON64bitChannels(t *testing.T) {
+
+	json64 := fmt.Sprintf(
+		payloadTestJSONTemplate,
+		`{"channel": 0, "type": "L", "value": 18446744073709551615},
+		 {"channel": 1, "type": "l", "value": -9223372036854775808},
+		 {"channel": 2, "type": "l", "value": 9007199254740993}`,
+	)
+
+	var payload Payload
+	err := json.Unmarshal([]byte(json64), &payload)
+	assert.NoError(t, err)
+	assert.Len(t, payload.Payload.Channels, 3)
+
+	uint64Value, err := payload.Payload.Channels[0].GetUint64()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64Value, uint64(18446744073709551615))
+
+	int64Value, err := payload.Payload.Channels[1].GetInt64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64Value, int64(-9223372036854775808))
+
+	int64Value, err = payload.Payload.Channels[2].GetInt64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64Value, int64(9007199254740993))
+
+	// re-marshal keeps the literal
+	data, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "18446744073709551615")
+	assert.Contains(t, string(data), "9007199254740993")
+}
+
+func TestChannelGetOverflow(t *testing.T) {
+
+	var c Channel
+	err := json.Unmarshal([]byte(`{"channel": 0, "type": "L", "value": 18446744073709551615}`), &c)
+	assert.NoError(t, err)
+
+	_, err = c.GetInt64()
+	assert.Error(t, err)
+	_, err = c.GetUint()
+	assert.Error(t, err)
+
+	err = json.Unmarshal([]byte(`{"channel": 0, "type": "i", "value": -1}`), &c)
+	assert.NoError(t, err)
+
+	_, err = c.GetUint()
+	assert.Error(t, err)
+	intValue, err := c.GetInt()
+	assert.NoError(t, err)
+	assert.Equal(t, intValue, int32(-1))
+
+	err = json.Unmarshal([]byte(`{"channel": 0, "type": "d", "value": 1.5}`), &c)
+	assert.NoError(t, err)
+
+	_, err = c.GetInt()
+	assert.Error(t, err)
+	doubleValue, err := c.GetDouble()
+	assert.NoError(t, err)
+	assert.Equal(t, doubleValue, float64(1.5))
+
+	err = json.Unmarshal([]byte(`{"channel": 0, "type": "f", "value": 1e300}`), &c)
+	assert.NoError(t, err)
+
+	_, err = c.GetFloat()
+	assert.Error(t, err)
+}
+
+func TestChannelGetAfterSet(t *testing.T) {
+
+	c := newChannel(0)
+	c.SetUint64(uint64(18446744073709551615))
+
+	v, err := c.GetUint64()
+	assert.NoError(t, err)
+	assert.Equal(t, v, uint64(18446744073709551615))
+
+	_, err = c.GetInt64()
+	assert.Error(t, err)
+}
+
+func TestChannelGetTypeMismatch(t *testing.T) {
+
+	var c Channel
+	err := json.Unmarshal([]byte(`{"channel": 0, "type": "d", "value": 1}`), &c)
+	assert.NoError(t, err)
+
+	_, err = c.GetInt()
+	assert.Error(t, err)
+	_, err = c.GetUint()
+	assert.Error(t, err)
+	_, err = c.GetFloat()
+	assert.Error(t, err)
+
+	doubleValue, err := c.GetDouble()
+	assert.NoError(t, err)
+	assert.Equal(t, doubleValue, float64(1))
+}
+
+func TestChannelTyped(t *testing.T) {
+
+	expects := []struct {
+		json  string
+		value interface{}
+	}{
+		{json: `{"channel": 0, "type": "i", "value": -1}`, value: int32(-1)},
+		{json: `{"channel": 0, "type": "I", "value": 4294967295}`, value: uint32(4294967295)},
+		{json: `{"channel": 0, "type": "l", "value": -9223372036854775808}`, value: int64(-9223372036854775808)},
+		{json: `{"channel": 0, "type": "L", "value": 18446744073709551615}`, value: uint64(18446744073709551615)},
+		{json: `{"channel": 0, "type": "f", "value": 1.5}`, value: float32(1.5)},
+		{json: `{"channel": 0, "type": "d", "value": 1.5}`, value: float64(1.5)},
+		{json: `{"channel": 0, "type": "b", "value": "0f1e2d3c4b5c6b7a"}`, value: [8]byte{0x0f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5c, 0x6b, 0x7a}},
+	}
+
+	for _, expect := range expects {
+		var c Channel
+		err := json.Unmarshal([]byte(expect.json), &c)
+		assert.NoError(t, err)
+
+		v, err := c.Typed()
+		assert.NoError(t, err)
+		assert.Equal(t, v.Type, c.ChannelType())
+		assert.Equal(t, v.Value, expect.value)
+	}
+
+	errors := []string{
+		`{"channel": 0, "type": "i", "value": 4294967295}`,
+		`{"channel": 0, "type": "I", "value": -1}`,
+		`{"channel": 0, "type": "b", "value": "0f1e"}`,
+		`{"channel": 0, "type": "b", "value": "zz1e2d3c4b5c6b7a"}`,
+		`{"channel": 0, "type": "x", "value": 1}`,
+	}
+	for _, data := range errors {
+		var c Channel
+		err := json.Unmarshal([]byte(data), &c)
+		assert.NoError(t, err)
+
+		_, err = c.Typed()
+		assert.Error(t, err)
+	}
+}
+
+func TestPayloadValidate(t *testing.T) {
+
+	payload := NewPayload("xxxxxxxx10xx")
+	payload.AddValueByInt(0, 1)
+	payload.AddValueByUint64(127, uint64(18446744073709551615))
+	payload.AddValueByHexString(1, "0f1e2d3c4b5c6b7a")
+	assert.NoError(t, payload.Validate())
+
+	var keepAlive Payload
+	err := json.Unmarshal([]byte(keepAliveTestJSON), &keepAlive)
+	assert.NoError(t, err)
+	assert.NoError(t, keepAlive.Validate())
+
+	// channel number
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.AddValueByInt(128, 1)
+	assert.Error(t, payload.Validate())
+
+	// hex string length and characters
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.AddValueByHexString(0, "FF01FF01")
+	assert.Error(t, payload.Validate())
+
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.AddValueByHexString(0, "0f1e2d3c4b5c6bzz")
+	assert.Error(t, payload.Validate())
+
+	// value range
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.Payload.Channels = append(payload.Payload.Channels, Channel{Channel: 0, Type: "i", Value: int64(1 << 40)})
+	assert.Error(t, payload.Validate())
+
+	// channel type
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.Payload.Channels = append(payload.Payload.Channels, Channel{Channel: 0, Type: "x", Value: 1})
+	assert.Error(t, payload.Validate())
+
+	// module and message type
+	payload = NewPayload("")
+	assert.Error(t, payload.Validate())
+
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.Type = "unknown"
+	assert.Error(t, payload.Validate())
+}
+
+func TestPayloadSamples(t *testing.T) {
+
+	var payload Payload
+	err := json.Unmarshal([]byte(payloadTestJSONChannelArray), &payload)
+	assert.NoError(t, err)
+
+	received := time.Date(2016, 12, 4, 0, 0, 0, 0, time.UTC)
+	samples, err := payload.SamplesAt(received)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+
+	channelTime := time.Date(2016, 12, 4, 4, 14, 27, 214224349, time.UTC)
+
+	assert.Equal(t, samples[0].Module, "XXXXXXXXX")
+	assert.EqualValues(t, samples[0].Channel, 1)
+	assert.Equal(t, samples[0].Type, ChannelTypeInt)
+	assert.True(t, samples[0].Time.Equal(channelTime))
+	assert.Equal(t, samples[0].Value, int32(1))
+
+	assert.EqualValues(t, samples[1].Channel, 2)
+	assert.Equal(t, samples[1].Type, ChannelTypeHexString)
+	assert.Equal(t, samples[1].Value, [8]byte{0x0f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5c, 0x6b, 0x7a})
+}
+
+func TestPayloadSamplesTimeFallback(t *testing.T) {
+
+	received := time.Date(2016, 12, 4, 0, 0, 0, 0, time.UTC)
+
+	// fallback to Payload.Datetime
+	var payload Payload
+	err := json.Unmarshal([]byte(fmt.Sprintf(payloadTestJSONTemplate, `{"channel": 0, "type": "i", "value": 1}`)), &payload)
+	assert.NoError(t, err)
+
+	samples, err := payload.SamplesAt(received)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+	assert.True(t, samples[0].Time.Equal(*payload.Datetime))
+
+	// fallback to received time
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.AddValueByDouble(0, 1.5)
+
+	samples, err = payload.SamplesAt(received)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+	assert.True(t, samples[0].Time.Equal(received))
+	assert.Equal(t, samples[0].Value, float64(1.5))
+
+	// keepalive has no samples
+	var keepAlive Payload
+	err = json.Unmarshal([]byte(keepAliveTestJSON), &keepAlive)
+	assert.NoError(t, err)
+	samples, err = keepAlive.Samples()
+	assert.NoError(t, err)
+	assert.Len(t, samples, 0)
+
+	// invalid value
+	payload = NewPayload("xxxxxxxx10xx")
+	payload.AddValueByHexString(0, "FF01FF01")
+	_, err = payload.Samples()
+	assert.Error(t, err)
+}
+
+func TestPayloadSamplesPartial(t *testing.T) {
+
+	payload := NewPayload("xxxxxxxx10xx")
+	payload.AddValueByInt(0, 1)
+	payload.AddValueByHexString(1, "FF01FF01")
+	payload.AddValueByDouble(2, 1.5)
+	payload.Payload.Channels = append(payload.Payload.Channels, Channel{Channel: 3, Type: "x", Value: 1})
+
+	// 変換できたチャンネルのみ返し、エラーには全ての不正なチャンネルを含める
+	samples, err := payload.SamplesAt(time.Now())
+	assert.Len(t, samples, 2)
+	assert.EqualValues(t, samples[0].Channel, 0)
+	assert.Equal(t, samples[0].Value, int32(1))
+	assert.EqualValues(t, samples[1].Channel, 2)
+	assert.Equal(t, samples[1].Value, float64(1.5))
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Channel 1:")
+		assert.Contains(t, err.Error(), "Channel 3:")
+		assert.NotContains(t, err.Error(), "Channel 0:")
+	}
+}
+
+func TestPayloadSplit(t *testing.T) {
+
+	payload := NewPayload("xxxxxxxx10xx")
+	for i := 0; i < 5; i++ {
+		payload.AddValueByInt(int64(i), int32(i))
+	}
+
+	chunks := payload.Split(2)
+	assert.Len(t, chunks, 3)
+	assert.Len(t, chunks[0].Payload.Channels, 2)
+	assert.Len(t, chunks[1].Payload.Channels, 2)
+	assert.Len(t, chunks[2].Payload.Channels, 1)
+	for _, chunk := range chunks {
+		assert.Equal(t, chunk.Module, "xxxxxxxx10xx")
+		assert.Equal(t, chunk.Type, PayloadTypesChannels)
+	}
+	assert.EqualValues(t, chunks[2].Payload.Channels[0].Channel, 4)
+
+	// chunks don't share channels with the source
+	chunks[0].Payload.Channels[0].Channel = 100
+	assert.EqualValues(t, payload.Payload.Channels[0].Channel, 0)
+
+	assert.Len(t, payload.Split(5), 1)
+	assert.Len(t, payload.Split(0), 1)
+}
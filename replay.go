@@ -0,0 +1,178 @@
+package sakura
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultDedupTTL ReplayGuard.MaxSkew/TTLが未指定の場合に重複を判定する期間
+const DefaultDedupTTL = 10 * time.Minute
+
+var (
+	// ErrStaleMessage Payload.Datetimeが許容範囲外のため拒否した
+	ErrStaleMessage = errors.New("Message datetime is outside of allowed skew")
+	// ErrDuplicateMessage 受信済みのメッセージのため破棄した
+	ErrDuplicateMessage = errors.New("Message is duplicated")
+)
+
+// DedupCache 受信済みメッセージのキーを記録するキャッシュ
+//
+// 複数のサーバーで重複を判定する場合はRedisなどの共有ストアで実装する
+type DedupCache interface {
+	// Add keyを記録する。ttl以内に記録済みの場合はfalseを返す
+	Add(key string, ttl time.Duration) (bool, error)
+	// Remove keyの記録を削除する(処理に失敗したメッセージの再送を受け付けるため)
+	Remove(key string) error
+}
+
+// ReplayGuard リプレイ攻撃と重複メッセージを防ぐ
+//
+// WebhookHandler.ReplayGuardに指定すると、署名検証とデコードの後に以下を行う
+//   - Payload.DatetimeがMaxSkew以上ずれているメッセージを拒否(403)
+//   - ボディのハッシュが一致するメッセージをTTL以内に受信済みの場合は、ハンドラを呼ばずに受理(200)
+//
+// ハンドラがエラーを返した場合は記録を削除するため、さくらのIoT Platformからの再送は処理される
+type ReplayGuard struct {
+	// MaxSkew Payload.Datetimeと受信時刻の差の許容範囲(0の場合は検証しない)
+	MaxSkew time.Duration
+	// RequireDatetime Payload.Datetimeが無いメッセージを拒否する
+	RequireDatetime bool
+
+	// Cache 重複の判定に利用するキャッシュ(nilの場合は判定しない)
+	Cache DedupCache
+	// TTL 重複を判定する期間(0の場合はMaxSkewの2倍、MaxSkewも0の場合はDefaultDedupTTL)
+	TTL time.Duration
+}
+
+// check メッセージを検証し、重複判定に利用したキーを返す
+func (g *ReplayGuard) check(body []byte, p *Payload, now time.Time) (string, error) {
+	if p.Datetime == nil {
+		if g.RequireDatetime {
+			return "", &DetailError{Err: ErrStaleMessage, Detail: "datetime is empty"}
+		}
+	} else if g.MaxSkew > 0 {
+		skew := now.Sub(*p.Datetime)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > g.MaxSkew {
+			return "", &DetailError{Err: ErrStaleMessage, Detail: skew.String()}
+		}
+	}
+
+	if g.Cache == nil {
+		return "", nil
+	}
+
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+	added, err := g.Cache.Add(key, g.ttl())
+	if err != nil {
+		return "", fmt.Errorf("Failed on checking duplicate: %s", err)
+	}
+	if !added {
+		return "", ErrDuplicateMessage
+	}
+	return key, nil
+}
+
+func (g *ReplayGuard) ttl() time.Duration {
+	switch {
+	case g.TTL > 0:
+		return g.TTL
+	case g.MaxSkew > 0:
+		return g.MaxSkew * 2
+	}
+	return DefaultDedupTTL
+}
+
+// forget 処理に失敗したメッセージの記録を削除
+func (g *ReplayGuard) forget(key string) {
+	if g != nil && key != "" && g.Cache != nil {
+		g.Cache.Remove(key)
+	}
+}
+
+// memoryDedupEntry MemoryDedupCacheの要素
+type memoryDedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// MemoryDedupCache 件数上限付きのメモリ上のDedupCache(並行利用可能)
+//
+// 上限を超えた場合は古いものから削除する
+type MemoryDedupCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+
+	now func() time.Time
+}
+
+// NewMemoryDedupCache 新規*MemoryDedupCache作成(sizeは記録する件数の上限)
+func NewMemoryDedupCache(size int) *MemoryDedupCache {
+	if size < 1 {
+		size = 1
+	}
+	return &MemoryDedupCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Add keyを記録する。ttl以内に記録済みの場合はfalseを返す
+func (c *MemoryDedupCache) Add(key string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if e, ok := c.entries[key]; ok {
+		if now.Before(e.Value.(*memoryDedupEntry).expires) {
+			return false, nil
+		}
+		c.remove(e)
+	}
+
+	// 期限切れのものと上限を超えたものを古い順に削除
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		if c.order.Len() < c.size && now.Before(e.Value.(*memoryDedupEntry).expires) {
+			break
+		}
+		c.remove(e)
+	}
+
+	c.entries[key] = c.order.PushBack(&memoryDedupEntry{key: key, expires: now.Add(ttl)})
+	return true, nil
+}
+
+// Remove keyの記録を削除
+func (c *MemoryDedupCache) Remove(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	return nil
+}
+
+// Len 記録している件数(期限切れのものを含む)
+func (c *MemoryDedupCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *MemoryDedupCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*memoryDedupEntry).key)
+}
@@ -0,0 +1,133 @@
+package sakura
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupCache(t *testing.T) {
+
+	now := time.Now()
+	c := NewMemoryDedupCache(2)
+	c.now = func() time.Time { return now }
+
+	added, err := c.Add("a", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	added, _ = c.Add("a", time.Minute)
+	assert.False(t, added)
+
+	// 期限切れ後は再度記録できる
+	now = now.Add(2 * time.Minute)
+	added, _ = c.Add("a", time.Minute)
+	assert.True(t, added)
+
+	// 上限を超えた場合は古いものから削除
+	c.Add("b", time.Minute)
+	c.Add("c", time.Minute)
+	assert.Equal(t, c.Len(), 2)
+	added, _ = c.Add("a", time.Minute)
+	assert.True(t, added)
+	added, _ = c.Add("c", time.Minute)
+	assert.False(t, added)
+
+	assert.NoError(t, c.Remove("c"))
+	added, _ = c.Add("c", time.Minute)
+	assert.True(t, added)
+}
+
+func replayTestPayload(t *testing.T, datetime time.Time) string {
+	p := NewPayload("module")
+	p.Datetime = &datetime
+	p.AddValueByInt(0, 1)
+
+	data, err := json.Marshal(p)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestWebhookHandlerReplayGuardSkew(t *testing.T) {
+
+	var reasons []error
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {},
+		ReplayGuard: &ReplayGuard{
+			MaxSkew: time.Minute,
+		},
+		RejectedFunc: func(r *http.Request, status int, err error) {
+			reasons = append(reasons, err)
+		},
+	}
+
+	w := postWebhook(h, replayTestPayload(t, time.Now()))
+	assert.Equal(t, w.Code, 200)
+
+	w = postWebhook(h, replayTestPayload(t, time.Now().Add(-time.Hour)))
+	assert.Equal(t, w.Code, 403)
+	w = postWebhook(h, replayTestPayload(t, time.Now().Add(time.Hour)))
+	assert.Equal(t, w.Code, 403)
+
+	// Datetimeが無いメッセージはRequireDatetimeの場合のみ拒否
+	noDatetime := `{"module":"module","type":"channels","payload":{"channels":[]}}`
+	w = postWebhook(h, noDatetime)
+	assert.Equal(t, w.Code, 200)
+	h.ReplayGuard.RequireDatetime = true
+	w = postWebhook(h, noDatetime)
+	assert.Equal(t, w.Code, 403)
+
+	assert.Len(t, reasons, 3)
+	for _, err := range reasons {
+		assert.True(t, isError(err, ErrStaleMessage), err.Error())
+	}
+}
+
+func TestWebhookHandlerReplayGuardDuplicate(t *testing.T) {
+
+	var (
+		handled int
+		fail    bool
+		reasons []error
+	)
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			handled++
+			if fail {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		}),
+		ReplayGuard: &ReplayGuard{
+			MaxSkew: time.Minute,
+			Cache:   NewMemoryDedupCache(100),
+		},
+		RejectedFunc: func(r *http.Request, status int, err error) {
+			reasons = append(reasons, err)
+		},
+	}
+
+	body := replayTestPayload(t, time.Now())
+
+	// 重複したメッセージはハンドラを呼ばずに受理する
+	w := postWebhook(h, body)
+	assert.Equal(t, w.Code, 200)
+	w = postWebhook(h, body)
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, handled, 1)
+	assert.Equal(t, reasons, []error{ErrDuplicateMessage})
+
+	// 処理に失敗したメッセージの再送は処理する
+	body = replayTestPayload(t, time.Now().Add(time.Second))
+	fail = true
+	w = postWebhook(h, body)
+	assert.Equal(t, w.Code, 500)
+	fail = false
+	w = postWebhook(h, body)
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, handled, 3)
+}
@@ -0,0 +1,192 @@
+package sakura
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+)
+
+// Matcher ペイロードがルートの条件を満たすか判定する関数
+type Matcher func(p *Payload) bool
+
+// MatchModule モジュールIDがいずれかと一致する
+func MatchModule(modules ...string) Matcher {
+	set := stringSet(modules)
+	return func(p *Payload) bool {
+		return set[p.Module]
+	}
+}
+
+// MatchModuleGlob モジュールIDがいずれかのパターン(path.Matchの書式)に一致する
+//
+// パターンの書式が不正な場合はpanicする
+func MatchModuleGlob(patterns ...string) Matcher {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Sprintf("sakura: invalid module pattern %q", pattern))
+		}
+	}
+	return func(p *Payload) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p.Module); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchType メッセージタイプがいずれかと一致する
+func MatchType(types ...string) Matcher {
+	set := stringSet(types)
+	return func(p *Payload) bool {
+		return set[p.Type]
+	}
+}
+
+// MatchChannel いずれかのチャンネルがfを満たす(type = channels以外のペイロードは一致しない)
+func MatchChannel(f func(c Channel) bool) Matcher {
+	return func(p *Payload) bool {
+		if !p.IsChannelValue() {
+			return false
+		}
+		for _, c := range p.Payload.Channels {
+			if f(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchAll 全ての条件を満たす
+func MatchAll(matchers ...Matcher) Matcher {
+	return func(p *Payload) bool {
+		for _, m := range matchers {
+			if !m(p) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny いずれかの条件を満たす
+func MatchAny(matchers ...Matcher) Matcher {
+	return func(p *Payload) bool {
+		for _, m := range matchers {
+			if m(p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// route 条件とハンドラの組
+type route struct {
+	match   Matcher
+	handler Handler
+}
+
+// Router ペイロードを条件に応じたハンドラへ振り分ける(並行利用可能)
+//
+// ルートは登録順に評価され、最初に条件を満たしたルートのハンドラのみ呼ばれる。
+// いずれも満たさない場合はFallbackが呼ばれる。
+// RouterはHandlerとしてWebhookHandler.Handlerに、
+// DispatchはWebhookHandlerFuncとしてWebhookHandler.HandleFuncなどに指定できる。
+// ゼロ値のRouterもそのまま利用できる
+//
+//	router := sakura.NewRouter()
+//	router.SetGroup("sensors", "module1", "module2")
+//	router.RouteFunc(router.MatchGroup("sensors"), handleSensor)
+//	router.RouteFunc(sakura.MatchModuleGlob("gps-*"), handleGPS)
+//	handler := &sakura.WebhookHandler{HandleFunc: router.Dispatch}
+type Router struct {
+	// Fallback いずれのルートにも一致しない場合のハンドラ(nilの場合は何もしない)
+	Fallback Handler
+
+	mu     sync.RWMutex
+	routes []route
+	groups map[string]map[string]bool
+}
+
+// NewRouter 新規*Router作成
+func NewRouter() *Router {
+	return &Router{
+		groups: map[string]map[string]bool{},
+	}
+}
+
+// Route 条件を満たすペイロードのハンドラを登録
+func (r *Router) Route(m Matcher, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, route{match: m, handler: h})
+}
+
+// RouteFunc 条件を満たすペイロードのハンドラ関数を登録
+func (r *Router) RouteFunc(m Matcher, f WebhookHandlerFunc) {
+	r.Route(m, f)
+}
+
+// SetGroup グループに属するモジュールIDを設定(modulesが空の場合はグループを削除)
+func (r *Router) SetGroup(name string, modules ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(modules) == 0 {
+		delete(r.groups, name)
+		return
+	}
+	if r.groups == nil {
+		r.groups = map[string]map[string]bool{}
+	}
+	r.groups[name] = stringSet(modules)
+}
+
+// MatchGroup モジュールIDがグループに属する
+//
+// グループのメンバーは判定時点のものが利用される
+func (r *Router) MatchGroup(name string) Matcher {
+	return func(p *Payload) bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.groups[name][p.Module]
+	}
+}
+
+// Handle 条件を満たすルートのハンドラを呼ぶ(Handlerインターフェースの実装)
+func (r *Router) Handle(ctx context.Context, p Payload) error {
+	if h := r.lookup(&p); h != nil {
+		return h.Handle(ctx, p)
+	}
+	return nil
+}
+
+// Dispatch 条件を満たすルートのハンドラを呼ぶ(ハンドラが返したエラーは無視される)
+func (r *Router) Dispatch(p Payload) {
+	r.Handle(context.Background(), p)
+}
+
+func (r *Router) lookup(p *Payload) Handler {
+	r.mu.RLock()
+	routes := r.routes
+	r.mu.RUnlock()
+
+	for _, route := range routes {
+		if route.match(p) {
+			return route.handler
+		}
+	}
+	return r.Fallback
+}
@@ -0,0 +1,120 @@
+package sakura
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func routerTestPayload(module string, values ...int32) Payload {
+	p := NewPayload(module)
+	for i, v := range values {
+		p.AddValueByInt(int64(i), v)
+	}
+	return p
+}
+
+func TestRouter(t *testing.T) {
+
+	var routed []string
+	record := func(name string) WebhookHandlerFunc {
+		return func(p Payload) { routed = append(routed, name+":"+p.Module) }
+	}
+
+	router := NewRouter()
+	router.SetGroup("group", "module-a", "module-b")
+	router.RouteFunc(MatchType(PayloadTypesConnection), record("connection"))
+	router.RouteFunc(MatchModule("module-x"), record("module"))
+	router.RouteFunc(router.MatchGroup("group"), record("group"))
+	router.RouteFunc(MatchModuleGlob("gps-*"), record("glob"))
+	router.RouteFunc(MatchChannel(func(c Channel) bool {
+		v, err := c.GetInt()
+		return err == nil && v > 100
+	}), record("channel"))
+	router.Fallback = record("fallback")
+
+	connection := NewPayload("module-a")
+	connection.Type = PayloadTypesConnection
+
+	router.Dispatch(connection)
+	router.Dispatch(routerTestPayload("module-x"))
+	router.Dispatch(routerTestPayload("module-b"))
+	router.Dispatch(routerTestPayload("gps-001"))
+	router.Dispatch(routerTestPayload("other", 1, 101))
+	router.Dispatch(routerTestPayload("other", 1, 2))
+
+	assert.Equal(t, routed, []string{
+		"connection:module-a",
+		"module:module-x",
+		"group:module-b",
+		"glob:gps-001",
+		"channel:other",
+		"fallback:other",
+	})
+
+	// グループのメンバーは判定時点のものが利用される
+	routed = nil
+	router.SetGroup("group", "module-c")
+	router.Dispatch(routerTestPayload("module-b"))
+	router.Dispatch(routerTestPayload("module-c"))
+	router.SetGroup("group")
+	router.Dispatch(routerTestPayload("module-c"))
+	assert.Equal(t, routed, []string{"fallback:module-b", "group:module-c", "fallback:module-c"})
+}
+
+func TestRouterZeroValue(t *testing.T) {
+
+	var routed []string
+	router := &Router{}
+	router.SetGroup("group", "module-a")
+	router.RouteFunc(router.MatchGroup("group"), func(p Payload) { routed = append(routed, p.Module) })
+
+	router.Dispatch(routerTestPayload("module-a"))
+	router.Dispatch(routerTestPayload("module-b"))
+	assert.Equal(t, routed, []string{"module-a"})
+}
+
+func TestRouterMatchCombination(t *testing.T) {
+
+	m := MatchAll(MatchModuleGlob("sensor-*"), MatchAny(MatchType(PayloadTypesChannels), MatchType(PayloadTypesLocation)))
+
+	p := NewPayload("sensor-1")
+	assert.True(t, m(&p))
+
+	p.Type = PayloadTypesLocation
+	assert.True(t, m(&p))
+
+	p.Type = PayloadTypesKeepAlive
+	assert.False(t, m(&p))
+
+	p = NewPayload("gps-1")
+	assert.False(t, m(&p))
+
+	assert.Panics(t, func() { MatchModuleGlob("[") })
+}
+
+func TestRouterWebhookHandler(t *testing.T) {
+
+	router := NewRouter()
+	router.Route(MatchAll(MatchModule("XXXXXXXXX"), MatchType(PayloadTypesChannels)), HandlerFunc(func(ctx context.Context, p Payload) error {
+		return fmt.Errorf("failed")
+	}))
+
+	// Handlerとして利用した場合はハンドラのエラーがステータスに反映される
+	h := &WebhookHandler{Handler: router}
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+
+	// Fallback未設定の場合は何もしない
+	w = postWebhook(h, connectionTestJSON)
+	assert.Equal(t, w.Code, 200)
+
+	received := make(chan Payload, 1)
+	router.Fallback = WebhookHandlerFunc(func(p Payload) { received <- p })
+	h = &WebhookHandler{ConnectedFunc: router.Dispatch}
+	w = postWebhook(h, connectionTestJSON)
+	assert.Equal(t, w.Code, 200)
+	p := receivePayload(t, received)
+	assert.True(t, p.IsConnection())
+}
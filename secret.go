@@ -0,0 +1,83 @@
+package sakura
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookSecret Outgoing Webhookの署名検証に利用するシークレット
+//
+// NotBefore/NotAfterを指定すると、その期間のみ有効となる(ゼロ値の場合は制限なし)
+type WebhookSecret struct {
+	// Name シークレットの識別名(どのシークレットで検証できたかの確認用)
+	Name      string
+	Value     string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ValidAt 指定時刻に有効なシークレットであるか判定
+func (s *WebhookSecret) ValidAt(t time.Time) bool {
+	if s.Value == "" {
+		return false
+	}
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) {
+		return false
+	}
+	if !s.NotAfter.IsZero() && t.After(s.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// SecretProvider リクエストに応じて署名検証に利用するシークレットの一覧を返す関数
+//
+// パスやヘッダによってシークレットを切り替える場合に利用する。
+// 一覧が空の場合は署名を検証できないものとして403を応答し、エラーの場合は500を応答する
+type SecretProvider func(r *http.Request) ([]WebhookSecret, error)
+
+// secrets 署名検証に利用するシークレットの一覧を返す
+//
+// SecretProviderが設定されていない場合はSecrets、Secretの順となる。
+// 検証不要(Secret/Secrets/SecretProviderのいずれも未設定)の場合はnilを返す
+func (h *WebhookHandler) secrets(r *http.Request) ([]WebhookSecret, error) {
+	if h.SecretProvider != nil {
+		secrets, err := h.SecretProvider(r)
+		if err != nil {
+			return nil, err
+		}
+		if secrets == nil {
+			secrets = []WebhookSecret{}
+		}
+		return secrets, nil
+	}
+
+	if h.Secret == "" && len(h.Secrets) == 0 {
+		return nil, nil
+	}
+	secrets := make([]WebhookSecret, 0, len(h.Secrets)+1)
+	secrets = append(secrets, h.Secrets...)
+	if h.Secret != "" {
+		secrets = append(secrets, WebhookSecret{Name: "Secret", Value: h.Secret})
+	}
+	return secrets, nil
+}
+
+// matchSecret 署名を検証できたシークレットを返す
+//
+// いずれのシークレットでも検証できない場合は最後の検証エラーを返す
+func (h *WebhookHandler) matchSecret(secrets []WebhookSecret, sig string, body []byte) (*WebhookSecret, error) {
+	now := time.Now()
+	err := fmt.Errorf("No valid secret")
+	for i := range secrets {
+		s := &secrets[i]
+		if !s.ValidAt(now) {
+			continue
+		}
+		if err = h.signer().Verify([]byte(s.Value), sig, body); err == nil {
+			return s, nil
+		}
+	}
+	return nil, err
+}
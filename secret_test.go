@@ -0,0 +1,107 @@
+package sakura
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yamamoto-febc/sakura-iot-go/signature"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postSignedWebhook(h http.Handler, path, secret, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	sig, _ := signature.Sign([]byte(secret), []byte(body))
+	req.Header.Set("X-Sakura-Signature", sig)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebhookSecretValidAt(t *testing.T) {
+
+	now := time.Now()
+	expects := []struct {
+		secret WebhookSecret
+		valid  bool
+	}{
+		{secret: WebhookSecret{Value: "secret"}, valid: true},
+		{secret: WebhookSecret{}, valid: false},
+		{secret: WebhookSecret{Value: "secret", NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}, valid: true},
+		{secret: WebhookSecret{Value: "secret", NotBefore: now.Add(time.Hour)}, valid: false},
+		{secret: WebhookSecret{Value: "secret", NotAfter: now.Add(-time.Hour)}, valid: false},
+	}
+
+	for i, expect := range expects {
+		assert.Equal(t, expect.secret.ValidAt(now), expect.valid, fmt.Sprintf("case %d", i))
+	}
+}
+
+func TestWebhookHandlerSecrets(t *testing.T) {
+
+	now := time.Now()
+	var matched []string
+
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {},
+		Secret:     "legacy",
+		Secrets: []WebhookSecret{
+			{Name: "old", Value: "old-secret", NotAfter: now.Add(time.Hour)},
+			{Name: "new", Value: "new-secret"},
+			{Name: "expired", Value: "expired-secret", NotAfter: now.Add(-time.Hour)},
+		},
+		SecretMatchedFunc: func(r *http.Request, s WebhookSecret) {
+			matched = append(matched, s.Name)
+		},
+	}
+
+	w := postSignedWebhook(h, "/", "old-secret", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	w = postSignedWebhook(h, "/", "new-secret", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	w = postSignedWebhook(h, "/", "legacy", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+
+	w = postSignedWebhook(h, "/", "expired-secret", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+	w = postSignedWebhook(h, "/", "unknown-secret", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+
+	assert.Equal(t, matched, []string{"old", "new", "Secret"})
+}
+
+func TestWebhookHandlerSecretProvider(t *testing.T) {
+
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {},
+		Secret:     "ignored",
+		SecretProvider: func(r *http.Request) ([]WebhookSecret, error) {
+			switch r.URL.Path {
+			case "/project1":
+				return []WebhookSecret{{Name: "project1", Value: "secret1"}}, nil
+			case "/project2":
+				return []WebhookSecret{{Name: "project2", Value: "secret2"}}, nil
+			case "/error":
+				return nil, fmt.Errorf("failed")
+			}
+			return nil, nil
+		},
+	}
+
+	w := postSignedWebhook(h, "/project1", "secret1", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	w = postSignedWebhook(h, "/project2", "secret1", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+	w = postSignedWebhook(h, "/project1", "ignored", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+
+	// シークレットが無い場合は検証できないため拒否する
+	w = postSignedWebhook(h, "/other", "ignored", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+
+	w = postSignedWebhook(h, "/error", "secret1", payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+}
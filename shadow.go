@@ -0,0 +1,181 @@
+package sakura
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ShadowChange Shadowの値が更新された際の通知内容
+type ShadowChange struct {
+	Module  string
+	Channel int64
+	// Old 更新前の値(初回の場合はnil)
+	Old *Channel
+	New Channel
+}
+
+// ShadowChangeFunc Shadowの値が更新された際に呼ばれる関数
+type ShadowChangeFunc func(ShadowChange)
+
+// Shadow モジュール/チャンネル毎に最後に受信した値を保持する(並行利用可能)
+//
+// Updateは WebhookHandler.HandleFunc としてそのまま利用できる
+//
+//	shadow := sakura.NewShadow()
+//	handler := &sakura.WebhookHandler{HandleFunc: shadow.Update}
+type Shadow struct {
+	mu        sync.RWMutex
+	modules   map[string]map[int64]Channel
+	listeners []ShadowChangeFunc
+}
+
+// NewShadow 新規*Shadow作成
+func NewShadow() *Shadow {
+	return &Shadow{
+		modules: map[string]map[int64]Channel{},
+	}
+}
+
+// Update ペイロードに含まれるチャンネル値を反映
+//
+// 各チャンネルの時刻はPayload.Samplesと同じ規則で決定し、
+// 保持している値より古い値は無視する。不正な値のチャンネルも無視する
+func (s *Shadow) Update(p Payload) {
+	if !p.IsChannelValue() {
+		return
+	}
+
+	now := time.Now()
+	base := now
+	if p.Datetime != nil {
+		base = *p.Datetime
+	}
+
+	changes := []ShadowChange{}
+
+	s.mu.Lock()
+	channels, ok := s.modules[p.Module]
+	if !ok {
+		channels = map[int64]Channel{}
+		s.modules[p.Module] = channels
+	}
+	for _, c := range p.Payload.Channels {
+		if c.Validate() != nil {
+			continue
+		}
+
+		t := base
+		if c.Datetime != nil {
+			t = *c.Datetime
+		}
+		c.Datetime = &t
+
+		change := ShadowChange{Module: p.Module, Channel: c.Channel, New: c}
+		if old, ok := channels[c.Channel]; ok {
+			if old.Datetime != nil && old.Datetime.After(t) {
+				continue
+			}
+			change.Old = &old
+		}
+
+		channels[c.Channel] = c
+		changes = append(changes, change)
+	}
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, change := range changes {
+		for _, f := range listeners {
+			f(change)
+		}
+	}
+}
+
+// OnChange 値が更新された際に呼ばれる関数を登録
+func (s *Shadow) OnChange(f ShadowChangeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+// Get 指定モジュール/チャンネルの最新の値を取得
+func (s *Shadow) Get(module string, channel int64) (Channel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.modules[module][channel]
+	return c, ok
+}
+
+// Snapshot 指定モジュールの全チャンネルの最新の値を取得
+func (s *Shadow) Snapshot(module string) map[int64]Channel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ret := make(map[int64]Channel, len(s.modules[module]))
+	for ch, c := range s.modules[module] {
+		ret[ch] = c
+	}
+	return ret
+}
+
+// Modules 値を保持しているモジュールの一覧を取得
+func (s *Shadow) Modules() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ret := make([]string, 0, len(s.modules))
+	for module := range s.modules {
+		ret = append(ret, module)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// SaveFile 保持している値をJSONとしてファイルへ保存
+func (s *Shadow) SaveFile(path string) error {
+	s.mu.RLock()
+	data, err := json.MarshalIndent(s.modules, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("Failed on Marshaling shadow : %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile SaveFileで保存したファイルから値を読み込む(保持している値は置き換える)
+func (s *Shadow) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	modules := map[string]map[int64]Channel{}
+	if err := json.Unmarshal(data, &modules); err != nil {
+		return fmt.Errorf("Failed on Unmarshaling shadow : %s", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modules = modules
+	return nil
+}
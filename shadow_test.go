@@ -0,0 +1,117 @@
+package sakura
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShadowUpdate(t *testing.T) {
+
+	shadow := NewShadow()
+
+	changes := []ShadowChange{}
+	shadow.OnChange(func(c ShadowChange) {
+		changes = append(changes, c)
+	})
+
+	t1 := time.Date(2016, 12, 4, 4, 14, 27, 0, time.UTC)
+	t2 := t1.Add(time.Second)
+
+	p, err := NewBuilder("module1").Datetime(t1).Int(0, 1).Double(1, 0.5).Build()
+	assert.NoError(t, err)
+	shadow.Update(p)
+
+	c, ok := shadow.Get("module1", 0)
+	assert.True(t, ok)
+	assert.Equal(t, c.ChannelType(), ChannelTypeInt)
+	assert.Equal(t, c.Value, int32(1))
+	assert.Equal(t, *c.Datetime, t1)
+
+	_, ok = shadow.Get("module1", 2)
+	assert.False(t, ok)
+	_, ok = shadow.Get("module2", 0)
+	assert.False(t, ok)
+
+	assert.Len(t, changes, 2)
+	assert.Nil(t, changes[0].Old)
+
+	// newer value
+	p, _ = NewBuilder("module1").Datetime(t2).Int(0, 2).Build()
+	shadow.Update(p)
+	c, _ = shadow.Get("module1", 0)
+	assert.Equal(t, c.Value, int32(2))
+	assert.Len(t, changes, 3)
+	if assert.NotNil(t, changes[2].Old) {
+		assert.Equal(t, changes[2].Old.Value, int32(1))
+	}
+
+	// older value is ignored
+	p, _ = NewBuilder("module1").Datetime(t1).Int(0, 3).Build()
+	shadow.Update(p)
+	c, _ = shadow.Get("module1", 0)
+	assert.Equal(t, c.Value, int32(2))
+	assert.Len(t, changes, 3)
+
+	snapshot := shadow.Snapshot("module1")
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, snapshot[1].Value, float64(0.5))
+	assert.Equal(t, shadow.Modules(), []string{"module1"})
+}
+
+func TestShadowConcurrentUpdate(t *testing.T) {
+
+	shadow := NewShadow()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p := NewPayload("module1")
+				p.AddValueByInt(int64(i), int32(j))
+				shadow.Update(p)
+				shadow.Snapshot("module1")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, shadow.Snapshot("module1"), 10)
+}
+
+func TestShadowPersistence(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sakura-shadow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shadow.json")
+
+	shadow := NewShadow()
+	p, _ := NewBuilder("module1").Uint64(0, 18446744073709551615).Hex(1, "0f1e2d3c4b5c6b7a").Build()
+	shadow.Update(p)
+	assert.NoError(t, shadow.SaveFile(path))
+
+	loaded := NewShadow()
+	assert.NoError(t, loaded.LoadFile(path))
+
+	c, ok := loaded.Get("module1", 0)
+	assert.True(t, ok)
+	v, err := c.GetUint64()
+	assert.NoError(t, err)
+	assert.Equal(t, v, uint64(18446744073709551615))
+	assert.NotNil(t, c.Datetime)
+
+	c, ok = loaded.Get("module1", 1)
+	assert.True(t, ok)
+	b, err := c.GetBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, b, [8]byte{0x0f, 0x1e, 0x2d, 0x3c, 0x4b, 0x5c, 0x6b, 0x7a})
+
+	assert.Error(t, loaded.LoadFile(filepath.Join(dir, "not-exists.json")))
+}
@@ -0,0 +1,7 @@
+// Package signature is signing and verification of Sakura-IoT-Platform webhook messages
+//
+// さくらのIoT PlatformのWebhookで利用されるメッセージ署名(HMAC)の作成/検証を行います。
+// net/http以外の経路で受信したメッセージの検証にも利用できます。
+//    - HMAC-SHA1(さくらのIoT Platformの既定) / HMAC-SHA256
+//    - ヘッダ名、"sha1="のような接頭辞の指定
+package signature
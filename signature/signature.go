@@ -0,0 +1,176 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// DefaultHeader 署名を格納するHTTPヘッダ名
+const DefaultHeader = "X-Sakura-Signature"
+
+// Algorithm 署名に利用するハッシュ関数
+type Algorithm int
+
+const (
+	// SHA1 HMAC-SHA1(さくらのIoT Platformの既定)
+	SHA1 Algorithm = iota
+	// SHA256 HMAC-SHA256
+	SHA256
+)
+
+// String アルゴリズム名
+func (a Algorithm) String() string {
+	switch a {
+	case SHA1:
+		return "sha1"
+	case SHA256:
+		return "sha256"
+	}
+	return fmt.Sprintf("Algorithm(%d)", int(a))
+}
+
+func (a Algorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("Unsupported algorithm %s", a)
+}
+
+// 検証失敗の理由
+var (
+	ErrMissingSignature = errors.New("Signature is empty")
+	ErrInvalidPrefix    = errors.New("Signature prefix is invalid")
+	ErrInvalidLength    = errors.New("Signature length is invalid")
+	ErrInvalidEncoding  = errors.New("Signature is not hex string")
+	ErrMismatch         = errors.New("Signature does not match")
+)
+
+// DetailError 詳細付きの検証失敗の理由(ErrはErr*のいずれか)
+type DetailError struct {
+	Err    error
+	Detail string
+}
+
+// Error エラーメッセージ
+func (e *DetailError) Error() string {
+	return e.Err.Error() + ": " + e.Detail
+}
+
+// Unwrap 検証失敗の理由を返す
+func (e *DetailError) Unwrap() error {
+	return e.Err
+}
+
+// Signer 署名の形式
+//
+// ゼロ値はさくらのIoT Platformの既定(HMAC-SHA1、X-Sakura-Signatureヘッダ、接頭辞なし)となる
+type Signer struct {
+	Algorithm Algorithm
+	// Header 署名を格納するHTTPヘッダ名(空の場合はDefaultHeader)
+	Header string
+	// Prefix 署名の接頭辞(例: "sha1=")
+	Prefix string
+}
+
+// Default さくらのIoT Platformの既定の形式
+var Default = &Signer{}
+
+// Sign 既定の形式でbodyの署名を作成
+func Sign(secret, body []byte) (string, error) {
+	return Default.Sign(secret, body)
+}
+
+// Verify 既定の形式でbodyの署名を検証
+func Verify(secret []byte, sig string, body []byte) error {
+	return Default.Verify(secret, sig, body)
+}
+
+// HeaderName 署名を格納するHTTPヘッダ名
+func (s *Signer) HeaderName() string {
+	if s.Header == "" {
+		return DefaultHeader
+	}
+	return s.Header
+}
+
+// Sign bodyの署名(接頭辞 + 16進文字列)を作成
+//
+// 未対応のAlgorithmを指定した場合はエラーを返す
+func (s *Signer) Sign(secret, body []byte) (string, error) {
+	mac, err := s.mac(secret, body)
+	if err != nil {
+		return "", err
+	}
+	return s.Prefix + hex.EncodeToString(mac), nil
+}
+
+// Verify bodyの署名を検証
+//
+// 検証できない場合はErrMissingSignature/ErrMismatch、またはErrInvalidPrefix/ErrInvalidLength/ErrInvalidEncodingを持つ*DetailErrorを返す
+func (s *Signer) Verify(secret []byte, sig string, body []byte) error {
+	h, err := s.Algorithm.hash()
+	if err != nil {
+		return err
+	}
+
+	if sig == "" {
+		return ErrMissingSignature
+	}
+	if !strings.HasPrefix(sig, s.Prefix) {
+		return &DetailError{Err: ErrInvalidPrefix, Detail: fmt.Sprintf("expected %q", s.Prefix)}
+	}
+	sig = sig[len(s.Prefix):]
+
+	size := h().Size()
+	if len(sig) != hex.EncodedLen(size) {
+		return &DetailError{Err: ErrInvalidLength, Detail: fmt.Sprintf("expected %d characters of %s, got %d", hex.EncodedLen(size), s.Algorithm, len(sig))}
+	}
+	actual, err := hex.DecodeString(sig)
+	if err != nil {
+		return &DetailError{Err: ErrInvalidEncoding, Detail: err.Error()}
+	}
+
+	expected, err := s.mac(secret, body)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, actual) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// SignRequest bodyの署名をリクエストのヘッダへ設定
+func (s *Signer) SignRequest(r *http.Request, secret, body []byte) error {
+	sig, err := s.Sign(secret, body)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(s.HeaderName(), sig)
+	return nil
+}
+
+// VerifyRequest リクエストのヘッダの署名でbodyを検証
+func (s *Signer) VerifyRequest(r *http.Request, secret, body []byte) error {
+	return s.Verify(secret, r.Header.Get(s.HeaderName()), body)
+}
+
+func (s *Signer) mac(secret, body []byte) ([]byte, error) {
+	h, err := s.Algorithm.hash()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,102 @@
+package signature
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	testSecret = []byte("key")
+	testBody   = []byte("The quick brown fox jumps over the lazy dog")
+)
+
+// sign testBodyの署名を作成
+func sign(t *testing.T, s *Signer, secret []byte) string {
+	sig, err := s.Sign(secret, testBody)
+	assert.NoError(t, err)
+	return sig
+}
+
+// reason 検証失敗の理由(*DetailErrorの場合はErr)
+func reason(err error) error {
+	if de, ok := err.(*DetailError); ok {
+		return de.Err
+	}
+	return err
+}
+
+func TestSign(t *testing.T) {
+
+	sig, err := Sign(testSecret, testBody)
+	assert.NoError(t, err)
+	assert.Equal(t, sig, "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9")
+
+	s := &Signer{Algorithm: SHA256, Prefix: "sha256="}
+	assert.Equal(t, sign(t, s, testSecret), "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8")
+
+	// 未対応のAlgorithmはpanicせずエラーとなる
+	_, err = (&Signer{Algorithm: Algorithm(99)}).Sign(testSecret, testBody)
+	assert.EqualError(t, err, "Unsupported algorithm Algorithm(99)")
+}
+
+func TestVerify(t *testing.T) {
+
+	sig := sign(t, Default, testSecret)
+	assert.NoError(t, Verify(testSecret, sig, testBody))
+	assert.NoError(t, Verify(testSecret, strings.ToUpper(sig), testBody))
+
+	expects := []struct {
+		sig string
+		err error
+	}{
+		{sig: "", err: ErrMissingSignature},
+		{sig: sig[:39], err: ErrInvalidLength},
+		{sig: "zz" + sig[2:], err: ErrInvalidEncoding},
+		{sig: sign(t, Default, []byte("other")), err: ErrMismatch},
+	}
+	for _, expect := range expects {
+		err := Verify(testSecret, expect.sig, testBody)
+		assert.Equal(t, reason(err), expect.err, "%q: %v", expect.sig, err)
+	}
+
+	err := Verify(testSecret, sig[:39], testBody)
+	assert.EqualError(t, err, "Signature length is invalid: expected 40 characters of sha1, got 39")
+}
+
+func TestVerifyPrefix(t *testing.T) {
+
+	s := &Signer{Algorithm: SHA256, Prefix: "sha256="}
+	sig := sign(t, s, testSecret)
+	assert.NoError(t, s.Verify(testSecret, sig, testBody))
+
+	err := s.Verify(testSecret, strings.TrimPrefix(sig, "sha256="), testBody)
+	assert.Equal(t, reason(err), ErrInvalidPrefix)
+
+	// SHA1の署名はSHA256として検証できない
+	err = s.Verify(testSecret, "sha256="+sign(t, Default, testSecret), testBody)
+	assert.Equal(t, reason(err), ErrInvalidLength)
+
+	err = (&Signer{Algorithm: Algorithm(99)}).Verify(testSecret, sig, testBody)
+	assert.EqualError(t, err, "Unsupported algorithm Algorithm(99)")
+}
+
+func TestSignRequest(t *testing.T) {
+
+	req := httptest.NewRequest("POST", "/", nil)
+	assert.NoError(t, Default.SignRequest(req, testSecret, testBody))
+	assert.Equal(t, req.Header.Get("X-Sakura-Signature"), "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9")
+	assert.NoError(t, Default.VerifyRequest(req, testSecret, testBody))
+
+	s := &Signer{Header: "X-Signature"}
+	req = httptest.NewRequest("POST", "/", nil)
+	assert.NoError(t, s.SignRequest(req, testSecret, testBody))
+	assert.Equal(t, req.Header.Get("X-Signature"), "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9")
+	assert.Equal(t, req.Header.Get("X-Sakura-Signature"), "")
+	assert.NoError(t, s.VerifyRequest(req, testSecret, testBody))
+
+	req = httptest.NewRequest("POST", "/", nil)
+	assert.Error(t, (&Signer{Algorithm: Algorithm(99)}).SignRequest(req, testSecret, testBody))
+	assert.Equal(t, req.Header.Get("X-Sakura-Signature"), "")
+}
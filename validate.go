@@ -0,0 +1,62 @@
+package sakura
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ChannelMin チャンネル番号の最小値
+	ChannelMin = 0
+	// ChannelMax チャンネル番号の最大値
+	ChannelMax = 127
+)
+
+// IsValid 既知の型コードであるか判定
+func (t ChannelType) IsValid() bool {
+	switch t {
+	case ChannelTypeInt, ChannelTypeUint, ChannelTypeInt64, ChannelTypeUint64,
+		ChannelTypeFloat, ChannelTypeDouble, ChannelTypeHexString:
+		return true
+	}
+	return false
+}
+
+// Validate チャンネル番号/型コード/値が妥当であるか検証
+func (c *Channel) Validate() error {
+	if c.Channel < ChannelMin || ChannelMax < c.Channel {
+		return fmt.Errorf("Channel %d: channel must be between %d to %d", c.Channel, ChannelMin, ChannelMax)
+	}
+	if !c.ChannelType().IsValid() {
+		return fmt.Errorf("Channel %d: unknown channel type %q", c.Channel, c.Type)
+	}
+	if _, err := c.Typed(); err != nil {
+		return fmt.Errorf("Channel %d: %s", c.Channel, err)
+	}
+	return nil
+}
+
+// Validate ペイロードタイプ/モジュール/各チャンネルが妥当であるか検証
+func (p *Payload) Validate() error {
+	errors := []string{}
+
+	switch {
+	case !IsKnownMessageType(p.Type):
+		errors = append(errors, fmt.Sprintf("Unknown payload type %q", p.Type))
+	case !p.IsKeepAlive() && p.Module == "":
+		errors = append(errors, "Module is required")
+	}
+
+	if p.IsChannelValue() {
+		for i := range p.Payload.Channels {
+			if err := p.Payload.Channels[i].Validate(); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+
+	if len(errors) != 0 {
+		return fmt.Errorf("Invalid payload:\n%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
@@ -2,111 +2,439 @@ package sakura
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yamamoto-febc/sakura-iot-go/signature"
+	"io"
+	"mime"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 )
 
+// DefaultRetryAfter is default value of Retry-After header when WebhookHandler.Pool is full
+const DefaultRetryAfter = 5 * time.Second
+
+// DefaultMaxBodySize is default value of WebhookHandler.MaxBodySize
+const DefaultMaxBodySize = 1 << 20
+
+// Reasons of rejecting request, passed to WebhookHandler.RejectedFunc
+var (
+	ErrMethodNotAllowed     = errors.New("Request method is not POST")
+	ErrUnsupportedMediaType = errors.New("Content-Type is not application/json")
+	ErrBodyTooLarge         = errors.New("Request body is too large")
+	ErrInvalidSignature     = errors.New("Invalid signature")
+	ErrInvalidPayload       = errors.New("Invalid payload")
+)
+
+// DetailError is error with detail message, Err is one of the Err* variables (e.g. ErrInvalidPayload)
+type DetailError struct {
+	Err    error
+	Detail string
+}
+
+// Error implements error interface
+func (e *DetailError) Error() string {
+	return e.Err.Error() + ": " + e.Detail
+}
+
+// Unwrap returns underlying error
+func (e *DetailError) Unwrap() error {
+	return e.Err
+}
+
 // WebhookHandlerFunc is type of handling request function
 type WebhookHandlerFunc func(Payload)
 
 // WebhookHandler is type to handling Webhook that receive from Sakura-IoT-platform
+//
+// ServeHTTP responds following status codes
+//
+//   - 200 : message is handled (or no callback is set for keepalive, location and other message types)
+//   - 400 : request body is not valid payload (or no callback is set for channels and connection messages)
+//   - 403 : signature is invalid, or message is stale (ReplayGuard)
+//   - 405 : request method is not POST
+//   - 413 : request body is larger than MaxBodySize
+//   - 500 : SecretProvider or DedupCache returned error
+//   - 415 : Content-Type is not application/json (only if RequireJSON is true)
+//   - UnhandledStatus : no callback is set for the message type (if UnhandledStatus is set)
+//   - ErrorStatus(err) : Handler returned error, or Pool is full (500 if it is not in 100-999)
 type WebhookHandler struct {
 	// Secret is used to sign payload by HMAC-SHA1
 	Secret string
 
+	// Secrets are also used to verify signature, with optional validity period.
+	// Set both old and new secrets while rotating the secret.
+	Secrets []WebhookSecret
+
+	// SecretProvider returns secrets for the request. If it is set, Secret/Secrets are not used.
+	SecretProvider SecretProvider
+
+	// SecretMatchedFunc is called with the secret that verified the signature
+	SecretMatchedFunc func(r *http.Request, s WebhookSecret)
+
+	// Signer is format of signature (default: HMAC-SHA1 in "X-Sakura-Signature" header)
+	Signer *signature.Signer
+
+	// ReplayGuard rejects stale messages and drops duplicated messages
+	ReplayGuard *ReplayGuard
+
+	// DiscardRawBody does not set copy of request body to Envelope.RawBody (default: false, RawBody is set).
+	// Set true to avoid copying body per request if RawBody is not used
+	DiscardRawBody bool
+
+	// LazyValue leaves Channel.Value unset (nil) and keeps channel values undecoded until they are read
+	// by Channel getters or GetValue(), so that decoding does not allocate per channel value
+	LazyValue bool
+
+	// MaxBodySize is maximum size of request body in bytes.
+	// Default is DefaultMaxBodySize, negative value means unlimited.
+	MaxBodySize int64
+
+	// RequireJSON rejects request that Content-Type is not application/json
+	RequireJSON bool
+
+	// Strict rejects payload that has unknown fields (including case-insensitive match of known fields)
+	Strict bool
+
+	// RejectedFunc is called when request is rejected before calling callbacks,
+	// err is one of ErrMethodNotAllowed, ErrUnsupportedMediaType, ErrBodyTooLarge, ErrInvalidSignature (*DetailError),
+	// ErrInvalidPayload (*DetailError), ErrStaleMessage (*DetailError), ErrDuplicateMessage (with status 200) or error of SecretProvider/DedupCache
+	RejectedFunc func(r *http.Request, status int, err error)
+
 	// HandleFunc is called when received  [type = channels] message
 	HandleFunc WebhookHandlerFunc
 
 	// ConnectedFunc is called when received  [type = connection] message
 	ConnectedFunc WebhookHandlerFunc
 
+	// LocationFunc is called when received  [type = location] message
+	LocationFunc WebhookHandlerFunc
+
+	// KeepAliveFunc is called when received  [type = keepalive] message
+	KeepAliveFunc WebhookHandlerFunc
+
+	// MessageFuncs is called when received message of other types, keyed by message type
+	MessageFuncs map[string]WebhookHandlerFunc
+
+	// UnknownFunc is called when received message of other types that has no entry in MessageFuncs
+	UnknownFunc WebhookHandlerFunc
+
+	// UnhandledStatus is HTTP status code (100-999) responded when no callback is set for the message type.
+	// Default is 400 for channels and connection messages, and 200 (acknowledge and drop the message)
+	// for other message types, as WebhookHandler did before UnhandledStatus was added.
+	// Set 200 to acknowledge all of them, or 4xx/5xx to reject, then Sakura-IoT-platform retries the delivery.
+	UnhandledStatus int
+
+	// Handler is called synchronously when received message of any type.
+	// If it is set, HandleFunc/ConnectedFunc/LocationFunc/MessageFuncs are not used.
+	Handler Handler
+
+	// ErrorStatus returns HTTP status code for error returned from Handler (default: DefaultErrorStatus)
+	ErrorStatus func(err error) int
+
+	// Pool is used to handle messages instead of starting goroutine per message.
+	// When its queue is full, responds 503 with Retry-After header.
+	// Use NewOrderedWorkerPool to handle messages from same module in received order.
+	Pool *WorkerPool
+
+	// RetryAfter is value of Retry-After header when Pool is full (default: DefaultRetryAfter)
+	RetryAfter time.Duration
+
+	// Middlewares wrap the callback for each message, first one is outermost.
+	// They also apply to HandleFunc etc. running on goroutine or Pool.
+	// Panics on goroutine are always recovered and logged to Logger (the standard logger if logging is disabled),
+	// panics on Pool are recovered and passed to Pool.PanicFunc. Add Recover to respond 500 on panic in Handler.
+	Middlewares []Middleware
+
+	// HTTPMiddlewares wrap processing of each request, first one is outermost
+	HTTPMiddlewares []HTTPMiddleware
+
+	// Logger is used to output logs (default: NopLogger, or StdLogger with DEBUG level if Debug is true)
+	Logger Logger
+
+	// Debug enables DEBUG log to the standard logger, it is ignored if Logger is set
 	Debug bool
 }
 
 // ServeHTTP is implements http.Handler interface
 func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	status := 400
-	defer func() {
-		w.WriteHeader(status)
-	}()
-
-	out := func(string, ...interface{}) {}
-	if h.Debug {
-		out = log.Printf
+	var next http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(h.serve(w, r))
+	})
+	for i := len(h.HTTPMiddlewares) - 1; i >= 0; i-- {
+		next = h.HTTPMiddlewares[i](next)
 	}
+	next.ServeHTTP(w, r)
+}
 
-	out("[DEBUG] Request received\n")
+// serve handles the request and returns HTTP status code to respond
+func (h *WebhookHandler) serve(w http.ResponseWriter, r *http.Request) int {
+	logger := h.logger()
+	debug := logEnabled(logger, LogLevelDebug)
+	receivedAt := time.Now()
 
-	if r.Method == "POST" {
-		out("[DEBUG] Request method is POST\n")
+	reject := func(status int, err error) int {
+		logger.Log(LogLevelInfo, "Request rejected", "status", status, "reason", err, "remote", r.RemoteAddr)
+		if h.RejectedFunc != nil {
+			h.RejectedFunc(r, status, err)
+		}
+		return status
+	}
 
-		bufbody := new(bytes.Buffer)
-		bufbody.ReadFrom(r.Body)
-		body := bufbody.Bytes()
+	if debug {
+		logger.Log(LogLevelDebug, "Request received", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	}
 
-		// Secretが設定されている場合は"X-Sakura-Signature"を検証
-		if h.Secret != "" {
-			signature := r.Header.Get("X-Sakura-Signature")
-			if !h.verifySignature([]byte(h.Secret), signature, body) {
-				status = 403
-				out("[DEBUG] Invalid signature:%s", signature)
-				return
-			}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		return reject(405, ErrMethodNotAllowed)
+	}
+	if h.RequireJSON && !isJSONContentType(r.Header.Get("Content-Type")) {
+		return reject(415, ErrUnsupportedMediaType)
+	}
 
+	bufbody := getBodyBuffer()
+	defer putBodyBuffer(bufbody)
+	if err := h.readBody(bufbody, r); err != nil {
+		if err == ErrBodyTooLarge {
+			return reject(413, err)
 		}
+		return reject(400, &DetailError{Err: ErrInvalidPayload, Detail: err.Error()})
+	}
+	body := bufbody.Bytes()
 
-		out("[DEBUG] Request body:%s\n", string(body))
-
-		var payload Payload
-		err := json.Unmarshal(body, &payload)
+	// Secretが設定されている場合は"X-Sakura-Signature"を検証
+	secrets, err := h.secrets(r)
+	if err != nil {
+		return reject(500, fmt.Errorf("Failed on getting secrets: %s", err))
+	}
+	sig := r.Header.Get(h.signer().HeaderName())
+	var secretName string
+	if secrets != nil {
+		secret, err := h.matchSecret(secrets, sig, body)
 		if err != nil {
-			return
+			if debug {
+				logger.Log(LogLevelDebug, "Invalid signature", "signature", sig)
+			}
+			return reject(403, &DetailError{Err: ErrInvalidSignature, Detail: err.Error()})
 		}
+		secretName = secret.Name
+		if debug {
+			logger.Log(LogLevelDebug, "Signature verified", "secret", secret.Name)
+		}
+		if h.SecretMatchedFunc != nil {
+			h.SecretMatchedFunc(r, *secret)
+		}
+	}
 
-		if payload.IsChannelValue() {
-			if h.HandleFunc == nil {
-				out("[INFO] HandleFunc is nil\n")
-				return
-			}
+	if debug {
+		logger.Log(LogLevelDebug, "Request body", "body", string(body))
+	}
 
-			go h.HandleFunc(payload)
+	var payload Payload
+	err = decodePayload(body, &payload, h.LazyValue)
+	if err == nil && h.Strict {
+		err = checkUnknownFields(body, &payload)
+	}
+	if err != nil {
+		return reject(400, &DetailError{Err: ErrInvalidPayload, Detail: err.Error()})
+	}
+
+	var dedupKey string
+	if h.ReplayGuard != nil {
+		dedupKey, err = h.ReplayGuard.check(body, &payload, receivedAt)
+		switch {
+		case err == ErrDuplicateMessage:
+			return reject(200, err)
+		case isError(err, ErrStaleMessage):
+			return reject(403, err)
+		case err != nil:
+			return reject(500, err)
 		}
+	}
 
-		if payload.IsConnection() {
-			if h.ConnectedFunc == nil {
-				out("[INFO] ConnectedFunc is nil\n")
-				return
-			}
+	name, handler, async := h.handler(payload.Type)
+	if handler == nil {
+		status := h.unhandledStatus(payload.Type)
+		if status >= 300 {
+			h.ReplayGuard.forget(dedupKey)
+		}
+		logger.Log(LogLevelInfo, "Message is not handled", "handler", name, "module", payload.Module, "type", payload.Type, "status", status)
+		return status
+	}
+	ctx := &envelopeContext{
+		Context: r.Context(),
+		envelope: Envelope{
+			Payload:    payload,
+			Signature:  sig,
+			SecretName: secretName,
+			Header:     r.Header,
+			RemoteAddr: r.RemoteAddr,
+			Path:       r.URL.Path,
+			ReceivedAt: receivedAt,
+		},
+	}
+	if !h.DiscardRawBody {
+		// bodyはプールへ戻すためEnvelopeにはコピーを設定する
+		ctx.envelope.RawBody = append([]byte(nil), body...)
+	}
+	if err := h.dispatch(ctx, Chain(handler, h.Middlewares...), async, payload); err != nil {
+		h.ReplayGuard.forget(dedupKey)
+		status := h.errorStatus(err)
+		if isError(err, ErrQueueFull) {
+			w.Header().Set("Retry-After", h.retryAfter())
+		}
+		logger.Log(LogLevelWarn, "Failed on handling message", "handler", name, "module", payload.Module, "type", payload.Type, "status", status, "error", err)
+		return status
+	}
+
+	return 200
+}
+
+// readBody reads request body up to MaxBodySize into buf
+func (h *WebhookHandler) readBody(buf *bytes.Buffer, r *http.Request) error {
+	limit := h.MaxBodySize
+	if limit == 0 {
+		limit = DefaultMaxBodySize
+	}
+	if limit < 0 {
+		_, err := buf.ReadFrom(r.Body)
+		return err
+	}
+
+	if r.ContentLength > limit {
+		return ErrBodyTooLarge
+	}
+	if _, err := buf.ReadFrom(io.LimitReader(r.Body, limit+1)); err != nil {
+		return err
+	}
+	if int64(buf.Len()) > limit {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+func isJSONContentType(v string) bool {
+	mediaType, _, err := mime.ParseMediaType(v)
+	return err == nil && mediaType == "application/json"
+}
+
+// handler returns the Handler for the message type, its name and whether it is called asynchronously
+func (h *WebhookHandler) handler(typ string) (string, Handler, bool) {
+	if h.Handler != nil {
+		return "Handler", h.Handler, false
+	}
+
+	name, f := h.handlerFunc(typ)
+	if f == nil {
+		return name, nil, false
+	}
+	return name, f, true
+}
 
-			go h.ConnectedFunc(payload)
+// dispatch calls handler directly, on new goroutine, or via Pool
+func (h *WebhookHandler) dispatch(ctx context.Context, handler Handler, async bool, p Payload) error {
+	if async && h.Pool == nil {
+		// a panic on goroutine can not be recovered by net/http (Pool recovers it and calls Pool.PanicFunc)
+		logger := h.logger()
+		if logger == NopLogger {
+			// do not lose the panic even if logging is disabled
+			logger = nil
 		}
+		handler = &recoverLogHandler{next: handler, logger: logger}
+	}
 
-		status = 200
-	} else {
-		out("[DEBUG] Request method is not POST\n")
+	if async {
+		// the request context is canceled as soon as ServeHTTP returns, keep only its values (e.g. Envelope)
+		ctx = detachedContext{parent: ctx}
+		if h.Pool != nil {
+			return h.Pool.Submit(ctx, handler, p)
+		}
+		go handler.Handle(ctx, p)
+		return nil
 	}
+
+	if h.Pool != nil {
+		return h.Pool.Do(ctx, handler, p)
+	}
+	return handler.Handle(ctx, p)
 }
 
-func (h *WebhookHandler) verifySignature(secret []byte, signature string, body []byte) bool {
+// detachedContext is context that has values of parent, but is never canceled
+type detachedContext struct {
+	parent context.Context
+}
 
-	const signaturePrefix = ""
-	const signatureLength = 40 // len(SignaturePrefix) + len(hex(sha1))
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
 
-	if len(signature) != signatureLength || (signaturePrefix != "" && !strings.HasPrefix(signature, signaturePrefix)) {
-		return false
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// handlerFunc returns the callback for the message type and its name
+func (h *WebhookHandler) handlerFunc(typ string) (string, WebhookHandlerFunc) {
+	switch typ {
+	case PayloadTypesChannels:
+		return "HandleFunc", h.HandleFunc
+	case PayloadTypesConnection:
+		return "ConnectedFunc", h.ConnectedFunc
+	case PayloadTypesLocation:
+		return "LocationFunc", h.LocationFunc
+	case PayloadTypesKeepAlive:
+		return "KeepAliveFunc", h.KeepAliveFunc
 	}
+	if f, ok := h.MessageFuncs[typ]; ok {
+		return fmt.Sprintf("MessageFuncs[%q]", typ), f
+	}
+	return "UnknownFunc", h.UnknownFunc
+}
 
-	actual := make([]byte, 20)
-	hex.Decode(actual, []byte(signature))
+func (h *WebhookHandler) unhandledStatus(typ string) int {
+	if validStatus(h.UnhandledStatus) {
+		return h.UnhandledStatus
+	}
+	switch typ {
+	case PayloadTypesChannels, PayloadTypesConnection:
+		return 400
+	}
+	return 200
+}
+
+func (h *WebhookHandler) retryAfter() string {
+	d := h.RetryAfter
+	if d <= 0 {
+		d = DefaultRetryAfter
+	}
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+func (h *WebhookHandler) errorStatus(err error) int {
+	if h.ErrorStatus == nil {
+		return DefaultErrorStatus(err)
+	}
+	if status := h.ErrorStatus(err); validStatus(status) {
+		return status
+	}
+	return http.StatusInternalServerError
+}
 
-	computed := hmac.New(sha1.New, secret)
-	computed.Write(body)
-	signBody := []byte(computed.Sum(nil))
+func (h *WebhookHandler) logger() Logger {
+	switch {
+	case h.Logger != nil:
+		return h.Logger
+	case h.Debug:
+		return NewStdLogger(nil, LogLevelDebug)
+	}
+	return NopLogger
+}
 
-	return hmac.Equal(signBody, actual)
+func (h *WebhookHandler) signer() *signature.Signer {
+	if h.Signer == nil {
+		return signature.Default
+	}
+	return h.Signer
 }
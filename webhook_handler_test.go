@@ -0,0 +1,246 @@
+package sakura
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postWebhook(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func receivePayload(t *testing.T, c chan Payload) Payload {
+	select {
+	case p := <-c:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("callback is not called")
+	}
+	return Payload{}
+}
+
+func TestWebhookHandlerDispatch(t *testing.T) {
+
+	channels := make(chan Payload, 1)
+	connected := make(chan Payload, 1)
+	location := make(chan Payload, 1)
+	other := make(chan Payload, 1)
+
+	h := &WebhookHandler{
+		HandleFunc:    func(p Payload) { channels <- p },
+		ConnectedFunc: func(p Payload) { connected <- p },
+		LocationFunc:  func(p Payload) { location <- p },
+		MessageFuncs: map[string]WebhookHandlerFunc{
+			"unknown-type": func(p Payload) { other <- p },
+		},
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	p := receivePayload(t, channels)
+	assert.Len(t, p.Payload.Channels, 1)
+
+	w = postWebhook(h, connectionTestJSON)
+	assert.Equal(t, w.Code, 200)
+	p = receivePayload(t, connected)
+	m, ok := p.Connection()
+	assert.True(t, ok)
+	assert.True(t, m.IsOnline)
+
+	w = postWebhook(h, locationTestJSON)
+	assert.Equal(t, w.Code, 200)
+	p = receivePayload(t, location)
+	assert.True(t, p.IsLocation())
+
+	w = postWebhook(h, unknownTestJSON)
+	assert.Equal(t, w.Code, 200)
+	p = receivePayload(t, other)
+	assert.Equal(t, p.Type, "unknown-type")
+}
+
+func TestWebhookHandlerInvalidSignature(t *testing.T) {
+
+	h := &WebhookHandler{
+		Secret:     "secret",
+		HandleFunc: func(p Payload) {},
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 403)
+}
+
+func TestWebhookHandlerKeepAliveAndUnknown(t *testing.T) {
+
+	keepAlive := make(chan Payload, 1)
+	unknown := make(chan Payload, 1)
+
+	h := &WebhookHandler{
+		KeepAliveFunc: func(p Payload) { keepAlive <- p },
+		UnknownFunc:   func(p Payload) { unknown <- p },
+		MessageFuncs: map[string]WebhookHandlerFunc{
+			"registered-type": func(p Payload) {},
+		},
+	}
+
+	w := postWebhook(h, keepAliveTestJSON)
+	assert.Equal(t, w.Code, 200)
+	p := receivePayload(t, keepAlive)
+	assert.True(t, p.IsKeepAlive())
+
+	w = postWebhook(h, unknownTestJSON)
+	assert.Equal(t, w.Code, 200)
+	p = receivePayload(t, unknown)
+	assert.Equal(t, p.Type, "unknown-type")
+}
+
+func TestWebhookHandlerUnhandledStatus(t *testing.T) {
+
+	// 既定ではchannels/connectionは400、それ以外のメッセージは受理する
+	h := &WebhookHandler{}
+	expects := map[string]int{
+		payloadTestJSONInt: 400,
+		connectionTestJSON: 400,
+		keepAliveTestJSON:  200,
+		locationTestJSON:   200,
+		unknownTestJSON:    200,
+	}
+	for body, status := range expects {
+		w := postWebhook(h, body)
+		assert.Equal(t, w.Code, status, body)
+	}
+
+	h.UnhandledStatus = 501
+	for body := range expects {
+		w := postWebhook(h, body)
+		assert.Equal(t, w.Code, 501, body)
+	}
+
+	// 範囲外のステータスは既定値とする
+	h.UnhandledStatus = 1000
+	for body, status := range expects {
+		w := postWebhook(h, body)
+		assert.Equal(t, w.Code, status, body)
+	}
+}
+
+func TestWebhookHandlerBadRequest(t *testing.T) {
+
+	h := &WebhookHandler{HandleFunc: func(p Payload) {}}
+
+	w := postWebhook(h, `{"module":`)
+	assert.Equal(t, w.Code, 400)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 405)
+	assert.Equal(t, w.Header().Get("Allow"), "POST")
+}
+
+func TestWebhookHandlerMaxBodySize(t *testing.T) {
+
+	var reasons []error
+	h := &WebhookHandler{
+		HandleFunc:  func(p Payload) {},
+		MaxBodySize: int64(len(payloadTestJSONInt)),
+		RejectedFunc: func(r *http.Request, status int, err error) {
+			reasons = append(reasons, err)
+		},
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+
+	w = postWebhook(h, payloadTestJSONInt+" ")
+	assert.Equal(t, w.Code, 413)
+
+	// Content-Lengthが不明な場合も上限を超えた時点で拒否する
+	req := httptest.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader(payloadTestJSONInt+" ")))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 413)
+
+	assert.Equal(t, reasons, []error{ErrBodyTooLarge, ErrBodyTooLarge})
+}
+
+func TestWebhookHandlerRequireJSON(t *testing.T) {
+
+	var reasons []error
+	h := &WebhookHandler{
+		HandleFunc:  func(p Payload) {},
+		RequireJSON: true,
+		RejectedFunc: func(r *http.Request, status int, err error) {
+			reasons = append(reasons, err)
+		},
+	}
+
+	expects := []struct {
+		contentType string
+		status      int
+	}{
+		{contentType: "application/json", status: 200},
+		{contentType: "application/json; charset=utf-8", status: 200},
+		{contentType: "text/plain", status: 415},
+		{contentType: "", status: 415},
+	}
+
+	for _, expect := range expects {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(payloadTestJSONInt))
+		req.Header.Set("Content-Type", expect.contentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, expect.status, expect.contentType)
+	}
+	assert.Equal(t, reasons, []error{ErrUnsupportedMediaType, ErrUnsupportedMediaType})
+}
+
+func TestWebhookHandlerStrict(t *testing.T) {
+
+	var reasons []error
+	h := &WebhookHandler{
+		HandleFunc:    func(p Payload) {},
+		ConnectedFunc: func(p Payload) {},
+		Strict:        true,
+		RejectedFunc: func(r *http.Request, status int, err error) {
+			reasons = append(reasons, err)
+		},
+	}
+
+	for _, body := range []string{payloadTestJSONInt, payloadTestJSONHexString, connectionTestJSON, locationTestJSON, keepAliveTestJSON, unknownTestJSON} {
+		w := postWebhook(h, body)
+		assert.Equal(t, w.Code, 200, body)
+	}
+
+	invalids := []string{
+		`{"module":"m","type":"channels","extra":1}`,
+		`{"Module":"m","type":"channels"}`,
+		`{"module":"m","type":"channels","payload":{"channels":[{"channel":1,"type":"i","value":1,"extra":true}]}}`,
+		`{"module":"m","type":"connection","payload":{"is_online":true,"extra":1}}`,
+		`{"module":"m","type":"channels"} {}`,
+	}
+	for _, body := range invalids {
+		w := postWebhook(h, body)
+		assert.Equal(t, w.Code, 400, body)
+	}
+
+	assert.Len(t, reasons, len(invalids))
+	for _, err := range reasons {
+		assert.True(t, isError(err, ErrInvalidPayload), err.Error())
+	}
+	assert.Equal(t, reasons[2].Error(), `Invalid payload: Unknown field "payload.channels[0].extra"`)
+
+	// Strictでない場合は未知のフィールドを無視する
+	h.Strict = false
+	w := postWebhook(h, invalids[0])
+	assert.Equal(t, w.Code, 200)
+}
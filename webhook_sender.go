@@ -2,11 +2,9 @@ package sakura
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/yamamoto-febc/sakura-iot-go/signature"
 	"github.com/yamamoto-febc/sakura-iot-go/version"
 	"io/ioutil"
 	"net/http"
@@ -18,10 +16,33 @@ var WebhookSendRootURL = "https://api.sakura.io/incoming/v1/"
 // WebhookSenderUserAgent user-agent string
 var WebhookSenderUserAgent = fmt.Sprintf("sakura-iot-go/%s", version.Version)
 
+// DefaultMaxChannelsPerMessage is default limit of channels per one Incoming-Webhook message (0 = no limit)
+//
+// The default is 0 so that Send and SendAll post a payload as one message, as Send always did.
+// The platform's limit is not defined by this package, and a guessed value would reject or split
+// payloads that the platform accepts. Set WebhookSender.MaxChannels (or this variable) to the limit
+// of your platform to make SendAll split payloads.
+var DefaultMaxChannelsPerMessage = 0
+
 // WebhookSender is type to handling Webhook that send to Sakura-IoT-platform
 type WebhookSender struct {
 	Token  string
 	Secret string
+
+	// Signer is format of signature (default: HMAC-SHA1 in "X-Sakura-Signature" header)
+	Signer *signature.Signer
+
+	// MaxChannels is limit of channels per one message (0 = DefaultMaxChannelsPerMessage)
+	MaxChannels int
+
+	// Logger is destination of logs (default: NopLogger)
+	Logger Logger
+}
+
+// SendResult is result of sending one message by SendAll
+type SendResult struct {
+	Payload Payload
+	Err     error
 }
 
 // NewWebhookSender create new *WebhookSender
@@ -32,8 +53,71 @@ func NewWebhookSender(token string, secret string) *WebhookSender {
 	}
 }
 
+func (w *WebhookSender) maxChannels() int {
+	if w.MaxChannels > 0 {
+		return w.MaxChannels
+	}
+	return DefaultMaxChannelsPerMessage
+}
+
 // Send send new request to the Incoming-Webhook on Sakura-IoT-platform
+//
+// If MaxChannels is set, payload that has channels more than it is rejected, use SendAll instead.
 func (w *WebhookSender) Send(p Payload) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if max := w.maxChannels(); max > 0 && len(p.Payload.Channels) > max {
+		return fmt.Errorf("Payload has %d channels, exceeds limit %d (use SendAll)", len(p.Payload.Channels), max)
+	}
+
+	return w.send(p)
+}
+
+// SendAll split the payload by MaxChannels and send each message in order (without limit, it is sent as one message)
+//
+// It returns result of each message, and an error if any of them failed.
+func (w *WebhookSender) SendAll(p Payload) ([]SendResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	chunks := p.Split(w.maxChannels())
+	results := make([]SendResult, 0, len(chunks))
+	failed := 0
+	for _, chunk := range chunks {
+		err := w.send(chunk)
+		if err != nil {
+			failed++
+		}
+		results = append(results, SendResult{Payload: chunk, Err: err})
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("Send webhook failed: %d of %d messages", failed, len(chunks))
+	}
+	return results, nil
+}
+
+func (w *WebhookSender) logger() Logger {
+	if w.Logger != nil {
+		return w.Logger
+	}
+	return NopLogger
+}
+
+func (w *WebhookSender) send(p Payload) error {
+	err := w.post(p)
+	if err != nil {
+		w.logger().Log(LogLevelWarn, "Failed on sending webhook", "module", p.Module, "type", p.Type, "error", err)
+		return err
+	}
+	w.logger().Log(LogLevelDebug, "Webhook sent", "module", p.Module, "type", p.Type, "channels", len(p.Payload.Channels))
+	return nil
+}
+
+func (w *WebhookSender) post(p Payload) error {
 	var (
 		client = &http.Client{}
 		url    = fmt.Sprintf("%s/%s", WebhookSendRootURL, w.Token)
@@ -55,12 +139,13 @@ func (w *WebhookSender) Send(p Payload) error {
 	req.Header.Add("Content-Type", "application/json")
 
 	if w.Secret != "" {
-
-		computed := hmac.New(sha1.New, []byte(w.Secret))
-		computed.Write(bodyJSON)
-		signBody := []byte(computed.Sum(nil))
-
-		req.Header.Add("X-Sakura-Signature", hex.EncodeToString(signBody))
+		signer := w.Signer
+		if signer == nil {
+			signer = signature.Default
+		}
+		if err := signer.SignRequest(req, []byte(w.Secret), bodyJSON); err != nil {
+			return fmt.Errorf("Failed on signing request: %s", err)
+		}
 	}
 
 	req.Method = "POST"
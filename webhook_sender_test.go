@@ -1,8 +1,13 @@
 package sakura
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"github.com/yamamoto-febc/sakura-iot-go/signature"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -37,3 +42,123 @@ func TestWebhookSender_Send(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestWebhookSender_SendInvalidPayload(t *testing.T) {
+
+	sender := NewWebhookSender("dummy", "")
+	p := NewPayload("xxxxxxxx10xx")
+	p.AddValueByHexString(0, "FF01FF01")
+
+	err := sender.Send(p)
+	assert.Error(t, err)
+}
+
+func TestWebhookSender_SendAll(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		received []Payload
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		mu.Lock()
+		received = append(received, p)
+		mu.Unlock()
+
+		// reject the message that includes channel 4
+		for _, c := range p.Payload.Channels {
+			if c.Channel == 4 {
+				w.WriteHeader(400)
+				w.Write([]byte("rejected"))
+				return
+			}
+		}
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	defaultURL := WebhookSendRootURL
+	WebhookSendRootURL = server.URL
+	defer func() { WebhookSendRootURL = defaultURL }()
+
+	sender := NewWebhookSender("dummy", "")
+
+	// no limit by default
+	large := NewPayload("xxxxxxxx10xx")
+	for i := 0; i < 20; i++ {
+		large.AddValueByInt(int64(i+10), int32(i))
+	}
+	assert.NoError(t, sender.Send(large))
+	assert.Len(t, received, 1)
+	assert.Len(t, received[0].Payload.Channels, 20)
+	received = nil
+
+	sender.MaxChannels = 2
+
+	p := NewPayload("xxxxxxxx10xx")
+	for i := 0; i < 5; i++ {
+		p.AddValueByInt(int64(i), int32(i))
+	}
+
+	// Send rejects oversized payload
+	assert.Error(t, sender.Send(p))
+	assert.Len(t, received, 0)
+
+	results, err := sender.SendAll(p)
+	assert.Error(t, err)
+	assert.Len(t, results, 3)
+	assert.Len(t, received, 3)
+
+	assert.NoError(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+	assert.Error(t, results[2].Err)
+	assert.EqualValues(t, results[2].Payload.Payload.Channels[0].Channel, 4)
+
+	received = nil
+	p.ClearValues()
+	p.AddValueByInt(0, 0)
+	results, err = sender.SendAll(p)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, received, 1)
+}
+
+func TestWebhookSender_Signature(t *testing.T) {
+
+	signer := &signature.Signer{Algorithm: signature.SHA256, Header: "X-Signature", Prefix: "sha256="}
+
+	received := make(chan Payload, 1)
+	server := httptest.NewServer(&WebhookHandler{
+		Secret:     "secret",
+		Signer:     signer,
+		HandleFunc: func(p Payload) { received <- p },
+	})
+	defer server.Close()
+
+	defaultURL := WebhookSendRootURL
+	WebhookSendRootURL = server.URL + "/"
+	defer func() { WebhookSendRootURL = defaultURL }()
+
+	p := NewPayload("xxxxxxxx10xx")
+	p.AddValueByInt(0, 1)
+
+	sender := NewWebhookSender("dummy", "secret")
+	sender.Signer = signer
+	assert.NoError(t, sender.Send(p))
+	assert.Equal(t, receivePayload(t, received).Module, "xxxxxxxx10xx")
+
+	// 既定の形式(HMAC-SHA1)の署名は拒否される
+	sender.Signer = nil
+	assert.Error(t, sender.Send(p))
+
+	// 未対応のAlgorithmはpanicせずエラーとなる
+	sender.Signer = &signature.Signer{Algorithm: signature.Algorithm(99)}
+	err := sender.Send(p)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Unsupported algorithm")
+	}
+}
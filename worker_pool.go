@@ -0,0 +1,225 @@
+package sakura
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueueFull WorkerPoolのキューが満杯のためメッセージを受け付けられない
+var ErrQueueFull = NewStatusError(http.StatusServiceUnavailable, errors.New("Queue is full"))
+
+// ErrPoolClosed WorkerPoolが停止済み
+var ErrPoolClosed = NewStatusError(http.StatusServiceUnavailable, errors.New("Worker pool is closed"))
+
+// WorkerPoolStats WorkerPoolの統計情報
+type WorkerPoolStats struct {
+	// Submitted キューへ投入されたメッセージ数
+	Submitted uint64
+	// Dropped キューが満杯で破棄されたメッセージ数
+	Dropped uint64
+	// Processed 処理済みのメッセージ数
+	Processed uint64
+	// QueueDepth キューで処理を待っているメッセージ数
+	QueueDepth int
+	// QueueCapacity キューの容量
+	QueueCapacity int
+	// Workers ワーカー数
+	Workers int
+}
+
+// poolJob WorkerPoolで処理するメッセージ
+type poolJob struct {
+	ctx     context.Context
+	handler Handler
+	payload Payload
+	// done 処理結果の通知先(nilの場合は通知しない)
+	done chan error
+}
+
+// WorkerPool 上限付きのキューと固定数のワーカーでメッセージを処理する
+//
+// WebhookHandler.Poolに指定すると、メッセージ毎にgoroutineを起動する代わりにキューへ投入し、
+// キューが満杯の場合は503(Retry-After付き)を応答する
+//
+// ハンドラ内で発生したpanicはワーカーがrecoverし、PanicFuncを呼ぶ
+//
+//	pool := sakura.NewWorkerPool(8, 1024)
+//	defer pool.Close()
+//	handler := &sakura.WebhookHandler{HandleFunc: f, Pool: pool}
+type WorkerPool struct {
+	submitted uint64
+	dropped   uint64
+	processed uint64
+
+	mu     sync.RWMutex
+	closed bool
+	// queues NewWorkerPoolでは全ワーカー共通の1つ、NewOrderedWorkerPoolではワーカー毎
+	queues  []chan poolJob
+	workers int
+	wg      sync.WaitGroup
+
+	// PanicFunc ハンドラ内でpanicが発生した場合に呼ばれる(nilの場合は標準のlogパッケージへスタックトレースを出力)。
+	// Doの場合は*PanicErrorが返される
+	PanicFunc func(p Payload, err *PanicError)
+}
+
+// NewWorkerPool 新規*WorkerPool作成
+//
+// workersはワーカー数、depthはキューの容量(いずれも1未満の場合は1)
+func NewWorkerPool(workers, depth int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+
+	queue := make(chan poolJob, depth)
+	p := &WorkerPool{
+		queues:  []chan poolJob{queue},
+		workers: workers,
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work(queue)
+	}
+	return p
+}
+
+// NewOrderedWorkerPool Payload.Module毎に受信順で処理する新規*WorkerPool作成
+//
+// メッセージはモジュールIDのハッシュによりいずれかのワーカーへ割り当てられ、
+// 同じモジュールのメッセージは投入された順に1件ずつ処理される。
+// 異なるモジュールのメッセージは並行して処理される(同じワーカーへ割り当てられた場合を除く)。
+// depthはワーカー毎のキューの容量となる
+func NewOrderedWorkerPool(workers, depth int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+
+	p := &WorkerPool{
+		queues:  make([]chan poolJob, workers),
+		workers: workers,
+	}
+	p.wg.Add(workers)
+	for i := range p.queues {
+		p.queues[i] = make(chan poolJob, depth)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+func (p *WorkerPool) work(queue chan poolJob) {
+	defer p.wg.Done()
+	for job := range queue {
+		var err error
+		if err = job.ctx.Err(); err == nil {
+			var perr *PanicError
+			if perr, err = recoverHandle(job.ctx, job.handler, job.payload); perr != nil {
+				p.panicFunc()(job.payload, perr)
+			}
+		}
+		atomic.AddUint64(&p.processed, 1)
+		if job.done != nil {
+			job.done <- err
+		}
+	}
+}
+
+func (p *WorkerPool) panicFunc() func(p Payload, err *PanicError) {
+	if p.PanicFunc != nil {
+		return p.PanicFunc
+	}
+	return RecoverLog(nil)
+}
+
+// Submit メッセージをキューへ投入(ブロックしない)
+//
+// キューが満杯の場合はErrQueueFull、停止済みの場合はErrPoolClosedを返す
+func (p *WorkerPool) Submit(ctx context.Context, handler Handler, payload Payload) error {
+	return p.submit(poolJob{ctx: ctx, handler: handler, payload: payload})
+}
+
+// Do メッセージをキューへ投入し、処理の完了を待つ
+//
+// キューが満杯の場合は待たずにErrQueueFullを返す。
+// 完了前にctxが終了した場合はctx.Err()を返す(キュー内のメッセージは処理されない)
+func (p *WorkerPool) Do(ctx context.Context, handler Handler, payload Payload) error {
+	done := make(chan error, 1)
+	if err := p.submit(poolJob{ctx: ctx, handler: handler, payload: payload, done: done}); err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *WorkerPool) submit(job poolJob) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.queue(job.payload.Module) <- job:
+		atomic.AddUint64(&p.submitted, 1)
+		return nil
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+		return ErrQueueFull
+	}
+}
+
+// queue モジュールIDに対応するキューを返す
+func (p *WorkerPool) queue(module string) chan poolJob {
+	if len(p.queues) == 1 {
+		return p.queues[0]
+	}
+
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(module); i++ {
+		h ^= uint32(module[i])
+		h *= 16777619
+	}
+	return p.queues[h%uint32(len(p.queues))]
+}
+
+// Close 新規の投入を停止し、キュー内のメッセージの処理完了を待つ
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// Stats 統計情報を取得
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	stats := WorkerPoolStats{
+		Submitted: atomic.LoadUint64(&p.submitted),
+		Dropped:   atomic.LoadUint64(&p.dropped),
+		Processed: atomic.LoadUint64(&p.processed),
+		Workers:   p.workers,
+	}
+	for _, queue := range p.queues {
+		stats.QueueDepth += len(queue)
+		stats.QueueCapacity += cap(queue)
+	}
+	return stats
+}
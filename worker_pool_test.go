@@ -0,0 +1,256 @@
+package sakura
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler releaseが閉じられるまで処理をブロックするハンドラ
+func blockingHandler(started chan<- Payload, release <-chan struct{}) Handler {
+	return HandlerFunc(func(ctx context.Context, p Payload) error {
+		started <- p
+		<-release
+		return nil
+	})
+}
+
+func TestWorkerPool(t *testing.T) {
+
+	pool := NewWorkerPool(2, 4)
+
+	received := make(chan Payload, 4)
+	handler := WebhookHandlerFunc(func(p Payload) { received <- p })
+
+	for i := 0; i < 4; i++ {
+		err := pool.Submit(context.Background(), handler, NewPayload(fmt.Sprintf("module%d", i)))
+		assert.NoError(t, err)
+	}
+	pool.Close()
+
+	assert.Len(t, received, 4)
+
+	stats := pool.Stats()
+	assert.Equal(t, stats.Submitted, uint64(4))
+	assert.Equal(t, stats.Processed, uint64(4))
+	assert.Equal(t, stats.Dropped, uint64(0))
+	assert.Equal(t, stats.QueueDepth, 0)
+	assert.Equal(t, stats.QueueCapacity, 4)
+	assert.Equal(t, stats.Workers, 2)
+
+	err := pool.Submit(context.Background(), handler, NewPayload("module"))
+	assert.Equal(t, err, ErrPoolClosed)
+}
+
+func TestWorkerPoolQueueFull(t *testing.T) {
+
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+
+	started := make(chan Payload, 2)
+	release := make(chan struct{})
+	handler := blockingHandler(started, release)
+
+	// 1件目はワーカーで処理中、2件目はキューで待機
+	assert.NoError(t, pool.Submit(context.Background(), handler, NewPayload("module1")))
+	receivePayload(t, started)
+	assert.NoError(t, pool.Submit(context.Background(), handler, NewPayload("module2")))
+
+	err := pool.Submit(context.Background(), handler, NewPayload("module3"))
+	assert.Equal(t, err, ErrQueueFull)
+
+	stats := pool.Stats()
+	assert.Equal(t, stats.QueueDepth, 1)
+	assert.Equal(t, stats.Dropped, uint64(1))
+
+	close(release)
+}
+
+func TestWorkerPoolDo(t *testing.T) {
+
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+
+	err := pool.Do(context.Background(), HandlerFunc(func(ctx context.Context, p Payload) error {
+		return fmt.Errorf("failed")
+	}), NewPayload("module"))
+	assert.EqualError(t, err, "failed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pool.Do(ctx, HandlerFunc(func(ctx context.Context, p Payload) error {
+		return nil
+	}), NewPayload("module"))
+	assert.Equal(t, err, context.Canceled)
+}
+
+func TestWorkerPoolPanic(t *testing.T) {
+
+	var recovered []string
+	pool := NewWorkerPool(1, 2)
+	pool.PanicFunc = func(p Payload, err *PanicError) {
+		recovered = append(recovered, p.Module+":"+err.Error())
+	}
+	handler := HandlerFunc(func(ctx context.Context, p Payload) error {
+		panic("boom")
+	})
+
+	// ワーカーはpanic後も処理を継続する
+	err := pool.Do(context.Background(), handler, NewPayload("module1"))
+	assert.IsType(t, err, &PanicError{})
+	assert.NoError(t, pool.Submit(context.Background(), handler, NewPayload("module2")))
+	pool.Close()
+
+	assert.Equal(t, recovered, []string{"module1:Panic: boom", "module2:Panic: boom"})
+	assert.Equal(t, pool.Stats().Processed, uint64(2))
+}
+
+func TestWebhookHandlerPool(t *testing.T) {
+
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+
+	started := make(chan Payload, 2)
+	release := make(chan struct{})
+	defer close(release)
+
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {
+			started <- p
+			<-release
+		},
+		Pool: pool,
+	}
+
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+	receivePayload(t, started)
+
+	w = postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 200)
+
+	w = postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 503)
+	assert.Equal(t, w.Header().Get("Retry-After"), "5")
+
+	h.RetryAfter = 1500 * time.Millisecond
+	w = postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 503)
+	assert.Equal(t, w.Header().Get("Retry-After"), "2")
+
+	assert.Equal(t, pool.Stats().Dropped, uint64(2))
+}
+
+func TestWebhookHandlerPoolHandler(t *testing.T) {
+
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+
+	h := &WebhookHandler{
+		Handler: HandlerFunc(func(ctx context.Context, p Payload) error {
+			return fmt.Errorf("failed")
+		}),
+		Pool: pool,
+	}
+
+	// Handlerはプール経由でも処理結果がステータスに反映される
+	w := postWebhook(h, payloadTestJSONInt)
+	assert.Equal(t, w.Code, 500)
+	assert.Equal(t, w.Header().Get("Retry-After"), "")
+}
+
+func TestWebhookHandlerOrderedPool(t *testing.T) {
+
+	const (
+		modules  = 8
+		messages = 100
+	)
+
+	pool := NewOrderedWorkerPool(4, modules*messages)
+
+	var mu sync.Mutex
+	received := map[string][]int32{}
+
+	h := &WebhookHandler{
+		HandleFunc: func(p Payload) {
+			mu.Lock()
+			defer mu.Unlock()
+			v, err := p.Payload.Channels[0].GetInt()
+			assert.NoError(t, err)
+			received[p.Module] = append(received[p.Module], v)
+		},
+		Pool: pool,
+	}
+
+	// モジュール毎に並行してPOSTし、各モジュール内では連番の順にPOSTする
+	var wg sync.WaitGroup
+	for m := 0; m < modules; m++ {
+		wg.Add(1)
+		go func(module string) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				p := NewPayload(module)
+				p.AddValueByInt(0, int32(i))
+
+				body, err := json.Marshal(p)
+				assert.NoError(t, err)
+				w := postWebhook(h, string(body))
+				assert.Equal(t, w.Code, 200)
+			}
+		}(fmt.Sprintf("module%d", m))
+	}
+	wg.Wait()
+	pool.Close()
+
+	assert.Len(t, received, modules)
+	for module, seq := range received {
+		assert.Len(t, seq, messages, module)
+		for i, n := range seq {
+			if !assert.Equal(t, n, int32(i), module) {
+				break
+			}
+		}
+	}
+}
+
+func TestOrderedWorkerPoolParallel(t *testing.T) {
+
+	pool := NewOrderedWorkerPool(2, 1)
+	defer pool.Close()
+
+	// 異なるワーカーへ割り当てられるモジュールを選ぶ
+	blocked := "module0"
+	other := ""
+	for i := 1; other == ""; i++ {
+		module := fmt.Sprintf("module%d", i)
+		if pool.queue(module) != pool.queue(blocked) {
+			other = module
+		}
+	}
+
+	started := make(chan Payload, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	err := pool.Submit(context.Background(), blockingHandler(started, release), NewPayload(blocked))
+	assert.NoError(t, err)
+	receivePayload(t, started)
+
+	// 処理中のモジュールがあっても他のモジュールは処理される
+	err = pool.Do(context.Background(), HandlerFunc(func(ctx context.Context, p Payload) error {
+		return nil
+	}), NewPayload(other))
+	assert.NoError(t, err)
+
+	// 同じモジュールは前のメッセージの処理完了を待つ
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = pool.Do(ctx, HandlerFunc(func(ctx context.Context, p Payload) error {
+		return nil
+	}), NewPayload(blocked))
+	assert.Equal(t, err, context.DeadlineExceeded)
+}